@@ -0,0 +1,159 @@
+// Command storegen generates the types struct and database store for a table,
+// following the same pattern as the hand-written user and people stores.
+//
+// The table definition is read either from the Up sections of the migrations
+// or, when -dsn is given, from a live database's information_schema. It is
+// meant to be run through go generate from internal/database:
+//
+//	//go:generate go run ../../cmd/storegen -table widgets
+//
+// which writes ../types/widget.go and widget_store.go.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+func main() {
+	var (
+		tableName     = flag.String("table", "", "name of the table to generate a store for (required)")
+		typeName      = flag.String("type", "", "name of the Go type for a row (default: the table name in singular form)")
+		pluralName    = flag.String("plural", "", "plural used for the XWith constructor (default: the table name)")
+		migrationsDir = flag.String("migrations", "../../migrations", "directory containing the sql-migrate files")
+		dsn           = flag.String("dsn", "", "read the table from this database instead of the migrations")
+		typesDir      = flag.String("types", "../types", "directory to write the types file to")
+		storeDir      = flag.String("out", ".", "directory to write the store file to")
+	)
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "storegen: ", 0)
+	if *tableName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		t   *table
+		err error
+	)
+	if *dsn != "" {
+		t, err = tableFromDatabase(context.Background(), *dsn, *tableName)
+	} else {
+		t, err = tableFromMigrations(*migrationsDir, *tableName)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *typeName == "" {
+		*typeName = goName(strings.TrimSuffix(*tableName, "s"))
+	}
+	if *pluralName == "" {
+		*pluralName = goName(*tableName)
+	}
+
+	data, err := newTemplateData(t, *typeName, *pluralName)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	base := strings.ToLower(data.Lower)
+	if err := render(typesTemplate, data, filepath.Join(*typesDir, base+".go")); err != nil {
+		logger.Fatal(err)
+	}
+	if err := render(storeTemplate, data, filepath.Join(*storeDir, base+"_store.go")); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+type templateData struct {
+	Table    *table
+	Type     string
+	Plural   string
+	Lower    string
+	Receiver string
+	PK       *column
+	Insert   []*column
+	// TypeImports and StoreImports are the standard library packages the
+	// column types used in each file come from.
+	TypeImports  []string
+	StoreImports []string
+}
+
+func newTemplateData(t *table, typeName, plural string) (*templateData, error) {
+	pk := t.primaryKey()
+	if pk == nil {
+		return nil, fmt.Errorf("table %q has no primary key", t.Name)
+	}
+
+	data := &templateData{
+		Table:    t,
+		Type:     typeName,
+		Plural:   plural,
+		Lower:    lowerFirst(typeName),
+		Receiver: identifier(lowerFirst(typeName), "Row", receiverIdents),
+		PK:       pk,
+	}
+
+	for _, c := range t.Columns {
+		if c.insertable() {
+			data.Insert = append(data.Insert, c)
+		}
+	}
+	if len(data.Insert) == 0 {
+		return nil, fmt.Errorf("table %q has no columns without defaults to insert", t.Name)
+	}
+
+	// The store only mentions column types in the signatures taking the
+	// primary key and the values to insert.
+	data.TypeImports = imports(t.Columns)
+	data.StoreImports = imports(append([]*column{pk}, data.Insert...))
+	return data, nil
+}
+
+// imports returns the packages the Go types of the columns come from, sorted.
+func imports(columns []*column) []string {
+	used := map[string]bool{}
+	for _, c := range columns {
+		switch strings.TrimPrefix(c.GoType(), "*") {
+		case "time.Time":
+			used["time"] = true
+		case "json.RawMessage":
+			used["encoding/json"] = true
+		}
+	}
+
+	var imps []string
+	for _, imp := range []string{"encoding/json", "time"} {
+		if used[imp] {
+			imps = append(imps, imp)
+		}
+	}
+	return imps
+}
+
+func render(tmpl *template.Template, data *templateData, path string) error {
+	src, err := execute(tmpl, data)
+	if err != nil {
+		return fmt.Errorf("generating %s: %w", path, err)
+	}
+	return os.WriteFile(path, src, 0o644)
+}
+
+// execute renders the template and formats the result.
+func execute(tmpl *template.Template, data *templateData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
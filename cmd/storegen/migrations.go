@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	createTableRe = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([\w."]+)\s*\((.*)\)$`)
+	addColumnRe   = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?([\w."]+)\s+ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(.*)$`)
+	dropColumnRe  = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?([\w."]+)\s+DROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?([\w"]+)`)
+	dropTableRe   = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?([\w."]+)`)
+	constraintRe  = regexp.MustCompile(`(?i)^(CONSTRAINT|PRIMARY\s+KEY|UNIQUE|FOREIGN\s+KEY|CHECK|EXCLUDE)\b`)
+	tablePKRe     = regexp.MustCompile(`(?i)^(?:CONSTRAINT\s+\S+\s+)?PRIMARY\s+KEY\s*\(([^)]*)\)`)
+	dollarTagRe   = regexp.MustCompile(`^\w*$`)
+)
+
+// tableFromMigrations replays the Up sections of every migration in dir, in
+// order, and returns the resulting definition of the named table.
+func tableFromMigrations(dir, name string) (*table, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	tables := map[string]*table{}
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stmt := range splitStatements(upSection(string(contents))) {
+			if err := applyStatement(tables, stmt); err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+			}
+		}
+	}
+
+	t, ok := tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %q is not created by any migration in %s", name, dir)
+	}
+	return t, nil
+}
+
+// upSection returns the part of a sql-migrate file between the Up and Down
+// markers.
+func upSection(contents string) string {
+	var b strings.Builder
+	inUp := false
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "-- +migrate") {
+			fields := strings.Fields(trimmed)
+			inUp = len(fields) > 2 && fields[2] == "Up"
+			continue
+		}
+		if inUp {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// splitStatements splits SQL on semicolons, ignoring comments and anything
+// inside quotes or dollar-quoted bodies.
+func splitStatements(sql string) []string {
+	var (
+		stmts   []string
+		current strings.Builder
+		quote   string
+	)
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != "":
+			current.WriteByte(c)
+			if strings.HasPrefix(sql[i:], quote) {
+				current.WriteString(quote[1:])
+				i += len(quote) - 1
+				quote = ""
+			}
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = string(c)
+			current.WriteByte(c)
+		case c == '$':
+			end := strings.IndexByte(sql[i+1:], '$')
+			if end >= 0 && dollarTagRe.MatchString(sql[i+1:i+1+end]) {
+				quote = sql[i : i+end+2]
+				current.WriteString(quote)
+				i += end + 1
+			} else {
+				current.WriteByte(c)
+			}
+		case c == ';':
+			if s := strings.TrimSpace(current.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+func applyStatement(tables map[string]*table, stmt string) error {
+	if m := createTableRe.FindStringSubmatch(stmt); m != nil {
+		t := &table{Name: unquote(m[1])}
+		for _, def := range splitTopLevel(m[2]) {
+			if pk := tablePKRe.FindStringSubmatch(def); pk != nil {
+				for _, name := range strings.Split(pk[1], ",") {
+					if c := t.column(unquote(strings.TrimSpace(name))); c != nil {
+						c.PrimaryKey = true
+					}
+				}
+				continue
+			}
+			if constraintRe.MatchString(def) {
+				continue
+			}
+			c, err := parseColumn(def)
+			if err != nil {
+				return err
+			}
+			t.Columns = append(t.Columns, c)
+		}
+		tables[t.Name] = t
+		return nil
+	}
+
+	if m := addColumnRe.FindStringSubmatch(stmt); m != nil && !constraintRe.MatchString(m[2]) {
+		t, ok := tables[unquote(m[1])]
+		if !ok {
+			return nil
+		}
+		c, err := parseColumn(m[2])
+		if err != nil {
+			return err
+		}
+		if t.column(c.Name) == nil {
+			t.Columns = append(t.Columns, c)
+		}
+		return nil
+	}
+
+	if m := dropColumnRe.FindStringSubmatch(stmt); m != nil {
+		if t, ok := tables[unquote(m[1])]; ok {
+			t.dropColumn(unquote(m[2]))
+		}
+		return nil
+	}
+
+	if m := dropTableRe.FindStringSubmatch(stmt); m != nil {
+		delete(tables, unquote(m[1]))
+	}
+	return nil
+}
+
+var columnModifierRe = regexp.MustCompile(`(?i)\s(NOT\s+NULL|NULL|PRIMARY\s+KEY|DEFAULT|UNIQUE|REFERENCES|CHECK|CONSTRAINT|GENERATED|COLLATE)\b`)
+
+func parseColumn(def string) (*column, error) {
+	fields := strings.Fields(def)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("cannot parse column definition %q", def)
+	}
+
+	c := &column{Name: unquote(fields[0])}
+	rest := strings.TrimSpace(def[len(fields[0]):])
+
+	// The type runs up to the first modifier keyword.
+	c.SQLType = rest
+	if loc := columnModifierRe.FindStringIndex(" " + rest); loc != nil {
+		c.SQLType = strings.TrimSpace(rest[:loc[0]])
+	}
+	if c.SQLType == "" {
+		return nil, fmt.Errorf("column %q has no type in %q", c.Name, def)
+	}
+
+	upper := strings.ToUpper(rest)
+	c.PrimaryKey = strings.Contains(upper, "PRIMARY KEY")
+	c.NotNull = c.PrimaryKey || strings.Contains(upper, "NOT NULL")
+	c.HasDefault = strings.Contains(upper, "DEFAULT") ||
+		strings.Contains(upper, "GENERATED") ||
+		strings.HasSuffix(baseType(c.SQLType), "serial")
+	return c, nil
+}
+
+// splitTopLevel splits a column list on commas that aren't nested in
+// parentheses.
+func splitTopLevel(s string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+func unquote(ident string) string {
+	ident = strings.Trim(ident, `"`)
+	if i := strings.LastIndex(ident, "."); i >= 0 {
+		ident = strings.Trim(ident[i+1:], `"`)
+	}
+	return ident
+}
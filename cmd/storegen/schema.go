@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const informationSchemaColumnsQuery = `
+SELECT
+	c.column_name,
+	CASE WHEN c.data_type = 'USER-DEFINED' THEN c.udt_name ELSE c.data_type END,
+	c.is_nullable = 'NO',
+	c.column_default IS NOT NULL OR c.is_identity = 'YES' OR c.is_generated = 'ALWAYS',
+	EXISTS (
+		SELECT 1
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = tc.constraint_name
+			AND kcu.table_schema = tc.table_schema
+		WHERE tc.constraint_type = 'PRIMARY KEY'
+			AND tc.table_schema = c.table_schema
+			AND tc.table_name = c.table_name
+			AND kcu.column_name = c.column_name
+	)
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema() AND c.table_name = $1
+ORDER BY c.ordinal_position
+`
+
+// tableFromDatabase reads the definition of the named table from a live
+// database's information_schema.
+func tableFromDatabase(ctx context.Context, dsn, name string) (*table, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, informationSchemaColumnsQuery, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &table{Name: name}
+	for rows.Next() {
+		var c column
+		if err := rows.Scan(&c.Name, &c.SQLType, &c.NotNull, &c.HasDefault, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		t.Columns = append(t.Columns, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("table %q not found in the database", name)
+	}
+	return t, nil
+}
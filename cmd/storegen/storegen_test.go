@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// typesPath is the package the generated types file is written to, and that
+// the generated store imports.
+const typesPath = "github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+
+// TestGenerate generates the files for each table in testdata/migrations,
+// compares them with the golden files next to them, and type-checks them
+// against the packages they would be written to.
+func TestGenerate(t *testing.T) {
+	for _, name := range []string{"widgets", "types"} {
+		t.Run(name, func(t *testing.T) {
+			tbl, err := tableFromMigrations("testdata/migrations", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := newTemplateData(tbl, goName(strings.TrimSuffix(name, "s")), goName(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			typesSrc, err := execute(typesTemplate, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storeSrc, err := execute(storeTemplate, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base := strings.ToLower(data.Lower)
+			compareGolden(t, base+".go.golden", typesSrc)
+			compareGolden(t, base+"_store.go.golden", storeSrc)
+			typeCheck(t, typesSrc, storeSrc)
+		})
+	}
+}
+
+func compareGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the generated file, run go test -update if the change is intended:\n%s", path, got)
+	}
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// typeCheck checks the generated types file as part of the real types package,
+// and the generated store against it.
+func typeCheck(t *testing.T, typesSrc, storeSrc []byte) {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	source := importer.ForCompiler(fset, "source", nil)
+
+	typesDir := filepath.Join("..", "..", "internal", "types")
+	paths, err := filepath.Glob(filepath.Join(typesDir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typesFiles []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typesFiles = append(typesFiles, f)
+	}
+	generated, err := parser.ParseFile(fset, "generated.go", typesSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typesFiles = append(typesFiles, generated)
+
+	conf := types.Config{Importer: source}
+	typesPkg, err := conf.Check(typesPath, fset, typesFiles, nil)
+	if err != nil {
+		t.Fatalf("types file: %v", err)
+	}
+
+	store, err := parser.ParseFile(fset, "generated_store.go", storeSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf = types.Config{Importer: importerFunc(func(path string) (*types.Package, error) {
+		if path == typesPath {
+			return typesPkg, nil
+		}
+		return source.Import(path)
+	})}
+	if _, err := conf.Check("database", fset, []*ast.File{store}, nil); err != nil {
+		t.Fatalf("store file: %v", err)
+	}
+}
+
+func TestParseColumnWithoutType(t *testing.T) {
+	if c, err := parseColumn("id PRIMARY KEY"); err == nil {
+		t.Errorf("got %+v, want an error for a column without a type", c)
+	}
+	if got := (&column{Name: "x", NotNull: true}).GoType(); got != "string" {
+		t.Errorf("GoType of a column without a type = %q, want string", got)
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{got: (&column{Name: "type"}).Param(), want: "typeValue"},
+		{got: (&column{Name: "ctx"}).Param(), want: "ctxValue"},
+		{got: (&column{Name: "user_id"}).Param(), want: "userID"},
+		{got: identifier(lowerFirst("Type"), "Row", receiverIdents), want: "typeRow"},
+		{got: identifier(lowerFirst("Query"), "Row", receiverIdents), want: "queryRow"},
+		{got: identifier(lowerFirst("Widget"), "Row", receiverIdents), want: "widget"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+type table struct {
+	Name    string
+	Columns []*column
+}
+
+type column struct {
+	Name       string
+	SQLType    string
+	NotNull    bool
+	PrimaryKey bool
+	HasDefault bool
+}
+
+func (t *table) column(name string) *column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (t *table) dropColumn(name string) {
+	for i, c := range t.Columns {
+		if c.Name == name {
+			t.Columns = append(t.Columns[:i], t.Columns[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *table) primaryKey() *column {
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			return c
+		}
+	}
+	return nil
+}
+
+// GoType maps the column's SQL type onto the Go type it is scanned into.
+// Nullable columns are scanned into pointers.
+func (c *column) GoType() string {
+	var t string
+	switch base := baseType(c.SQLType); {
+	case base == "uuid", base == "text", base == "citext",
+		strings.HasPrefix(base, "varchar"), strings.HasPrefix(base, "character"), strings.HasPrefix(base, "char"):
+		t = "string"
+	case base == "serial", base == "bigserial", base == "bigint", base == "int8":
+		t = "int64"
+	case base == "integer", base == "int", base == "int4":
+		t = "int32"
+	case base == "smallint", base == "int2", base == "smallserial":
+		t = "int16"
+	case base == "boolean", base == "bool":
+		t = "bool"
+	case base == "real", base == "float4":
+		t = "float32"
+	case base == "double", base == "float8":
+		t = "float64"
+	case strings.HasPrefix(base, "timestamp"), base == "date":
+		t = "time.Time"
+	case base == "json", base == "jsonb":
+		t = "json.RawMessage"
+	case base == "bytea":
+		t = "[]byte"
+	default:
+		t = "string"
+	}
+
+	if !c.NotNull && !c.PrimaryKey && !strings.HasPrefix(t, "[]") && t != "json.RawMessage" {
+		return "*" + t
+	}
+	return t
+}
+
+// baseType returns the lowercased name of an SQL type without its modifiers,
+// e.g. varchar(10) for "VARCHAR(10) ARRAY", or "" if there is none.
+func baseType(sqlType string) string {
+	fields := strings.Fields(sqlType)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// Param is the name of the column when passed as a function argument.
+func (c *column) Param() string {
+	name := goName(c.Name)
+	if strings.HasPrefix(name, "ID") {
+		name = "id" + name[2:]
+	} else {
+		name = lowerFirst(name)
+	}
+	return identifier(name, "Value", createIdents)
+}
+
+// createIdents are the names Create uses besides its column parameters.
+var createIdents = map[string]bool{"basestore": true, "ctx": true, "q": true, "s": true}
+
+// receiverIdents are the names used by the store methods and scan function
+// that declare a variable for a row.
+var receiverIdents = map[string]bool{
+	"basestore": true, "errors": true, "pgx": true, "types": true,
+	"ctx": true, "err": true, "id": true, "opts": true, "q": true, "query": true,
+	"results": true, "rows": true, "s": true, "sc": true, "updated": true,
+}
+
+// identifier returns name, with suffix added if name is a Go keyword or one of
+// the names in taken.
+func identifier(name, suffix string, taken map[string]bool) string {
+	if token.IsKeyword(name) || taken[name] {
+		return name + suffix
+	}
+	return name
+}
+
+// insertable reports whether Create takes a value for the column. Columns the
+// database fills in on its own are left out.
+func (c *column) insertable() bool {
+	return !c.HasDefault
+}
+
+// goName converts a snake_case identifier into an exported Go name.
+func goName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		if part == "" {
+			continue
+		}
+		if strings.EqualFold(part, "id") {
+			b.WriteString("ID")
+			continue
+		}
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+// jsonName converts a snake_case identifier into the camelCase used in our
+// JSON tags, e.g. user_id becomes userId.
+func jsonName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		if part == "" {
+			continue
+		}
+		r := []rune(strings.ToLower(part))
+		if b.Len() > 0 {
+			r[0] = unicode.ToUpper(r[0])
+		}
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,202 @@
+package main
+
+import "text/template"
+
+var funcs = template.FuncMap{
+	"goName":     goName,
+	"jsonName":   jsonName,
+	"lowerFirst": lowerFirst,
+}
+
+var typesTemplate = template.Must(template.New("types").Funcs(funcs).Parse(`// Code generated by storegen. DO NOT EDIT.
+
+package types
+
+{{- if .TypeImports }}
+
+import (
+{{- range .TypeImports }}
+	"{{ . }}"
+{{- end }}
+)
+{{- end }}
+
+type {{ .Type }} struct {
+{{- range .Table.Columns }}
+	{{ goName .Name }} {{ .GoType }} ` + "`" + `json:"{{ jsonName .Name }}"` + "`" + `
+{{- end }}
+}
+`))
+
+var storeTemplate = template.Must(template.New("store").Funcs(funcs).Parse(`// Code generated by storegen. DO NOT EDIT.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+{{- range .StoreImports }}
+	"{{ . }}"
+{{- end }}
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/keegancsmith/sqlf"
+)
+
+const default{{ .Type }}Limit = 10
+
+type {{ .Lower }}NotFoundErr struct {
+	ID {{ .PK.GoType }}
+}
+
+func (e {{ .Lower }}NotFoundErr) Error() string {
+	return fmt.Sprintf("{{ .Lower }} with ID %v not found", e.ID)
+}
+
+var {{ .Lower }}Columns = []*sqlf.Query{
+{{- range .Table.Columns }}
+	sqlf.Sprintf("{{ $.Table.Name }}.{{ .Name }}"),
+{{- end }}
+}
+
+var {{ .Lower }}InsertColumns = []*sqlf.Query{
+{{- range .Insert }}
+	sqlf.Sprintf("{{ .Name }}"),
+{{- end }}
+}
+
+type {{ .Type }}Store interface {
+	basestore.ShareableStore
+
+	List(ctx context.Context, opts List{{ .Type }}Args) ([]*types.{{ .Type }}, error)
+	GetByID(ctx context.Context, id {{ .PK.GoType }}) (*types.{{ .Type }}, error)
+	Create(ctx context.Context{{ range .Insert }}, {{ .Param }} {{ .GoType }}{{ end }}) (*types.{{ .Type }}, error)
+	Update(ctx context.Context, {{ .Receiver }} *types.{{ .Type }}) (*types.{{ .Type }}, error)
+	Delete(ctx context.Context, id {{ .PK.GoType }}) error
+}
+
+type List{{ .Type }}Args struct {
+	Limit int
+}
+
+func {{ .Plural }}With(other basestore.ShareableStore) {{ .Type }}Store {
+	return &{{ .Lower }}Store{Store: basestore.NewWithHandle(other.Handle())}
+}
+
+type {{ .Lower }}Store struct {
+	*basestore.Store
+}
+
+var _ {{ .Type }}Store = &{{ .Lower }}Store{}
+
+func (s *{{ .Lower }}Store) List(ctx context.Context, opts List{{ .Type }}Args) ([]*types.{{ .Type }}, error) {
+	if opts.Limit == 0 {
+		opts.Limit = default{{ .Type }}Limit
+	}
+
+	query := basestore.Select({{ .Lower }}Columns...).
+		From("{{ .Table.Name }}").
+		OrderBy(basestore.Asc("{{ .PK.Name }}")).
+		Limit(opts.Limit).
+		Query()
+
+	rows, err := s.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*types.{{ .Type }}{}
+	for rows.Next() {
+		{{ .Receiver }}, err := scan{{ .Type }}(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, {{ .Receiver }})
+	}
+
+	return results, rows.Err()
+}
+
+func (s *{{ .Lower }}Store) GetByID(ctx context.Context, id {{ .PK.GoType }}) (*types.{{ .Type }}, error) {
+	q := basestore.Select({{ .Lower }}Columns...).
+		From("{{ .Table.Name }}").
+		Where(basestore.Eq("{{ .PK.Name }}", id)).
+		Limit(1).
+		Query()
+
+	{{ .Receiver }}, err := scan{{ .Type }}(s.QueryRow(ctx, q))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, {{ .Lower }}NotFoundErr{ID: id}
+		}
+		return nil, err
+	}
+	return {{ .Receiver }}, nil
+}
+
+func (s *{{ .Lower }}Store) Create(ctx context.Context{{ range .Insert }}, {{ .Param }} {{ .GoType }}{{ end }}) (*types.{{ .Type }}, error) {
+	q := basestore.Insert("{{ .Table.Name }}").
+		Columns({{ .Lower }}InsertColumns...).
+		Values({{ range $i, $c := .Insert }}{{ if $i }}, {{ end }}{{ $c.Param }}{{ end }}).
+		Returning({{ .Lower }}Columns...).
+		Query()
+
+	return scan{{ .Type }}(s.QueryRow(ctx, q))
+}
+
+func (s *{{ .Lower }}Store) Update(ctx context.Context, {{ .Receiver }} *types.{{ .Type }}) (*types.{{ .Type }}, error) {
+	q := basestore.Update("{{ .Table.Name }}").
+{{- range .Insert }}
+		Set("{{ .Name }}", {{ $.Receiver }}.{{ goName .Name }}).
+{{- end }}
+		Where(basestore.Eq("{{ .PK.Name }}", {{ .Receiver }}.{{ goName .PK.Name }})).
+		Returning({{ .Lower }}Columns...).
+		Query()
+
+	updated, err := scan{{ .Type }}(s.QueryRow(ctx, q))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, {{ .Lower }}NotFoundErr{ID: {{ .Receiver }}.{{ goName .PK.Name }}}
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *{{ .Lower }}Store) Delete(ctx context.Context, id {{ .PK.GoType }}) error {
+	q := basestore.Delete("{{ .Table.Name }}").
+		Where(basestore.Eq("{{ .PK.Name }}", id)).
+		Query()
+
+	res, err := s.ExecResult(ctx, q)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return {{ .Lower }}NotFoundErr{ID: id}
+	}
+	return nil
+}
+
+func scan{{ .Type }}(sc dbutil.Scanner) (*types.{{ .Type }}, error) {
+	var {{ .Receiver }} types.{{ .Type }}
+	if err := sc.Scan(
+{{- range .Table.Columns }}
+		&{{ $.Receiver }}.{{ goName .Name }},
+{{- end }}
+	); err != nil {
+		return nil, err
+	}
+
+	return &{{ .Receiver }}, nil
+}
+
+func Is{{ .Type }}NotFoundErr(err error) bool {
+	return errors.As(err, &{{ .Lower }}NotFoundErr{})
+}
+`))
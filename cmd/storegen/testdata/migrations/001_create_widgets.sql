@@ -0,0 +1,36 @@
+-- +migrate Up
+-- widgets has a column of every type storegen maps, under each of its names.
+CREATE TABLE widgets (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name text NOT NULL,
+    label citext,
+    code varchar(10) NOT NULL,
+    kind character varying(20),
+    grade char(1),
+    type text NOT NULL,
+    seq bigserial,
+    total bigint NOT NULL,
+    total8 int8,
+    quantity integer NOT NULL,
+    quantity4 int4,
+    legacy int,
+    rank smallint NOT NULL,
+    rank2 int2,
+    active boolean NOT NULL,
+    enabled bool,
+    ratio real,
+    ratio4 float4,
+    score double precision NOT NULL,
+    score8 float8,
+    due_on date,
+    starts_at timestamptz NOT NULL,
+    ends_at timestamp,
+    payload jsonb NOT NULL,
+    metadata json,
+    blob bytea,
+    address inet,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- +migrate Down
+DROP TABLE widgets;
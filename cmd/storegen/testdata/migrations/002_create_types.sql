@@ -0,0 +1,10 @@
+-- +migrate Up
+-- types is named so that its row variable would be the keyword type.
+CREATE TABLE types (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    created_at timestamptz NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE types;
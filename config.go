@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+)
+
+// runConfigCommand implements the config subcommand and returns the process
+// exit code.
+func runConfigCommand(cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "usage: %s [config flags] config print\n", os.Args[0])
+		return 2
+	}
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
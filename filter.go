@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/google/uuid"
+)
+
+// filterParam matches the query parameters filters are given in:
+// filter[field][op]=value.
+var filterParam = regexp.MustCompile(`^filter\[([^\[\]]*)\]\[([^\[\]]*)\]$`)
+
+// userFilter sets the list arguments for a filter from its value.
+type userFilter func(opts *database.ListUserArgs, value string) error
+
+// userFilters maps each field users can be filtered on to its operators.
+var userFilters = map[string]map[string]userFilter{
+	"id": {
+		"eq": func(opts *database.ListUserArgs, value string) error {
+			return setUserIDs(opts, []string{value})
+		},
+		"in": func(opts *database.ListUserArgs, value string) error {
+			return setUserIDs(opts, strings.Split(value, ","))
+		},
+	},
+	"username": {
+		"eq": func(opts *database.ListUserArgs, value string) error {
+			return setUsernames(opts, []string{value})
+		},
+		"in": func(opts *database.ListUserArgs, value string) error {
+			return setUsernames(opts, strings.Split(value, ","))
+		},
+		"prefix": func(opts *database.ListUserArgs, value string) error {
+			opts.UsernamePrefix = value
+			return nil
+		},
+		"contains": func(opts *database.ListUserArgs, value string) error {
+			opts.UsernameContains = value
+			return nil
+		},
+	},
+	"email": {
+		"eq": func(opts *database.ListUserArgs, value string) error {
+			opts.Email = value
+			return nil
+		},
+		"domain": func(opts *database.ListUserArgs, value string) error {
+			opts.EmailDomain = strings.TrimPrefix(value, "@")
+			return nil
+		},
+		"contains": func(opts *database.ListUserArgs, value string) error {
+			opts.EmailContains = value
+			return nil
+		},
+	},
+	"createdAt": {
+		"gte": func(opts *database.ListUserArgs, value string) error {
+			return setCreatedBound(&opts.CreatedSince, value)
+		},
+		"lt": func(opts *database.ListUserArgs, value string) error {
+			return setCreatedBound(&opts.CreatedUntil, value)
+		},
+		// between takes two comma-separated times, and is short for gte the
+		// first and lt the second.
+		"between": func(opts *database.ListUserArgs, value string) error {
+			since, until, ok := strings.Cut(value, ",")
+			if !ok {
+				return errors.New("must be two comma-separated RFC 3339 timestamps")
+			}
+			if err := setCreatedBound(&opts.CreatedSince, since); err != nil {
+				return err
+			}
+			return setCreatedBound(&opts.CreatedUntil, until)
+		},
+	},
+}
+
+// parseUserFilters sets the list arguments for the filters in query. An
+// unknown field or operator, or a value the operator can't take, is an error
+// naming the parameter at fault.
+func parseUserFilters(query url.Values, opts *database.ListUserArgs) error {
+	// Go through the parameters in order, so that the same query always
+	// fails in the same way.
+	var names []string
+	for name := range query {
+		if strings.HasPrefix(name, "filter") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := filterParam.FindStringSubmatch(name)
+		if m == nil {
+			return fmt.Errorf("%s: filters must be given as filter[field][op]=value", name)
+		}
+		field, op := m[1], m[2]
+
+		ops, ok := userFilters[field]
+		if !ok {
+			return fmt.Errorf("%s: unknown field %q, expected one of %s", name, field, strings.Join(sortedKeys(userFilters), ", "))
+		}
+		filter, ok := ops[op]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %q for %s, expected one of %s", name, op, field, strings.Join(sortedKeys(ops), ", "))
+		}
+
+		values := query[name]
+		if len(values) != 1 {
+			return fmt.Errorf("%s: given more than once", name)
+		}
+		if values[0] == "" {
+			return fmt.Errorf("%s: value is required", name)
+		}
+		if err := filter(opts, values[0]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setUserIDs(opts *database.ListUserArgs, ids []string) error {
+	if len(opts.IDs) > 0 {
+		return errors.New("only one of eq and in can be used for id")
+	}
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid uuid %q", id)
+		}
+	}
+	opts.IDs = ids
+	return nil
+}
+
+func setUsernames(opts *database.ListUserArgs, usernames []string) error {
+	if len(opts.Usernames) > 0 {
+		return errors.New("only one of eq and in can be used for username")
+	}
+	for _, username := range usernames {
+		if username == "" {
+			return errors.New("usernames must not be empty")
+		}
+	}
+	opts.Usernames = usernames
+	return nil
+}
+
+func setCreatedBound(bound *time.Time, value string) error {
+	if !bound.IsZero() {
+		return errors.New("between can't be combined with gte or lt")
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("%q is not an RFC 3339 timestamp", value)
+	}
+	*bound = t
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
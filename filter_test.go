@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+)
+
+func TestParseUserFilters(t *testing.T) {
+	const (
+		id1 = "8f6bc0d4-4b1c-4f5e-9f3a-2d1e0c9b8a71"
+		id2 = "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+	)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    database.ListUserArgs
+		wantErr string
+	}{
+		{name: "none", query: "limit=10"},
+		{name: "id eq", query: "filter[id][eq]=" + id1, want: database.ListUserArgs{IDs: []string{id1}}},
+		{name: "id in", query: "filter[id][in]=" + id1 + "," + id2, want: database.ListUserArgs{IDs: []string{id1, id2}}},
+		{name: "username in", query: "filter[username][in]=ann,bob", want: database.ListUserArgs{Usernames: []string{"ann", "bob"}}},
+		{
+			name:  "username prefix and contains",
+			query: "filter[username][prefix]=an&filter[username][contains]=N",
+			want:  database.ListUserArgs{UsernamePrefix: "an", UsernameContains: "N"},
+		},
+		{
+			name:  "email",
+			query: "filter[email][eq]=Ann@Example.com&filter[email][domain]=@example.com&filter[email][contains]=ann",
+			want:  database.ListUserArgs{Email: "Ann@Example.com", EmailDomain: "example.com", EmailContains: "ann"},
+		},
+		{
+			name:  "createdAt gte and lt",
+			query: "filter[createdAt][gte]=2024-01-01T00:00:00Z&filter[createdAt][lt]=2024-02-01T00:00:00Z",
+			want:  database.ListUserArgs{CreatedSince: since, CreatedUntil: until},
+		},
+		{
+			name:  "createdAt between",
+			query: "filter[createdAt][between]=2024-01-01T00:00:00Z,2024-02-01T00:00:00Z",
+			want:  database.ListUserArgs{CreatedSince: since, CreatedUntil: until},
+		},
+
+		{name: "not a filter", query: "filter[id]=x", wantErr: "filter[id]: filters must be given as filter[field][op]=value"},
+		{
+			name:    "unknown field",
+			query:   "filter[name][eq]=ann",
+			wantErr: `filter[name][eq]: unknown field "name", expected one of createdAt, email, id, username`,
+		},
+		{
+			name:    "unknown operator",
+			query:   "filter[email][prefix]=ann",
+			wantErr: `filter[email][prefix]: unknown operator "prefix" for email, expected one of contains, domain, eq`,
+		},
+		{name: "repeated", query: "filter[username][eq]=ann&filter[username][eq]=bob", wantErr: "filter[username][eq]: given more than once"},
+		{name: "empty", query: "filter[email][eq]=", wantErr: "filter[email][eq]: value is required"},
+		{name: "invalid uuid", query: "filter[id][eq]=42", wantErr: `filter[id][eq]: invalid uuid "42"`},
+		{name: "invalid uuid in list", query: "filter[id][in]=" + id1 + ",nope", wantErr: `filter[id][in]: invalid uuid "nope"`},
+		{
+			name:    "id eq and in",
+			query:   "filter[id][eq]=" + id1 + "&filter[id][in]=" + id2,
+			wantErr: "filter[id][in]: only one of eq and in can be used for id",
+		},
+		{name: "empty username in list", query: "filter[username][in]=ann,", wantErr: "filter[username][in]: usernames must not be empty"},
+		{
+			name:    "invalid timestamp",
+			query:   "filter[createdAt][gte]=yesterday",
+			wantErr: `filter[createdAt][gte]: "yesterday" is not an RFC 3339 timestamp`,
+		},
+		{
+			name:    "between with one timestamp",
+			query:   "filter[createdAt][between]=2024-01-01T00:00:00Z",
+			wantErr: "filter[createdAt][between]: must be two comma-separated RFC 3339 timestamps",
+		},
+		{
+			name:    "between and gte",
+			query:   "filter[createdAt][between]=2024-01-01T00:00:00Z,2024-02-01T00:00:00Z&filter[createdAt][gte]=2024-01-01T00:00:00Z",
+			wantErr: "filter[createdAt][gte]: between can't be combined with gte or lt",
+		},
+		{
+			name:    "between and lt",
+			query:   "filter[createdAt][lt]=2024-02-01T00:00:00Z&filter[createdAt][between]=2024-01-01T00:00:00Z,2024-02-01T00:00:00Z",
+			wantErr: "filter[createdAt][lt]: between can't be combined with gte or lt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got database.ListUserArgs
+			err = parseUserFilters(query, &got)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"clevergo.tech/jsend"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+)
+
+// readinessTimeout bounds each of the checks behind /readyz and /debug/db, so
+// that a hung database makes us unready rather than hanging the probe.
+const readinessTimeout = 2 * time.Second
+
+// healthz reports that the process is up and serving requests, whatever the
+// state of its dependencies.
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	jsend.Success(w, "ok", http.StatusOK)
+}
+
+// readyz reports whether we should be sent traffic: the database is reachable
+// and not degraded, the pool has a connection to spare, every migration has
+// been applied and the user cache is receiving invalidations. It fails as soon
+// as shutdown starts so that load balancers stop routing to us while requests
+// in flight finish.
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		jsend.Fail(w, map[string]string{"shutdown": "shutting down"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	checks := map[string]string{}
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	// The health monitor notices an outage without our help, so don't add to
+	// the load on a struggling database by pinging it too.
+	if health := s.db.Health(); health.Degraded {
+		check("database", fmt.Errorf("unreachable since %s: %s", health.Since.Format(time.RFC3339), health.Error))
+	} else {
+		check("database", s.db.Ping(ctx))
+	}
+
+	stat := s.db.Stat()
+	if stat.AcquiredConns() >= stat.MaxConns() {
+		check("pool", fmt.Errorf("all %d connections are in use", stat.MaxConns()))
+	} else {
+		check("pool", nil)
+	}
+
+	// Only look for pending migrations if the database is reachable.
+	if ready {
+		check("migrations", s.pendingMigrations.check(ctx, s.migrations))
+	}
+
+	if s.db.ListeningForUserCacheInvalidations() {
+		check("listener", nil)
+	} else {
+		check("listener", fmt.Errorf("not listening for user cache invalidations"))
+	}
+
+	if !ready {
+		jsend.Fail(w, checks, http.StatusServiceUnavailable)
+		return
+	}
+	jsend.Success(w, checks, http.StatusOK)
+}
+
+// pendingMigrationsTTL is how long readyz reuses the result of looking for
+// pending migrations, which only change when another instance migrates.
+const pendingMigrationsTTL = 30 * time.Second
+
+// pendingMigrationsCheck caches whether migrations are pending. sql-migrate
+// can't be given a deadline, so a probe that times out leaves the check running
+// in the background; later probes wait for that same check instead of starting
+// another one, so that a hung database doesn't pile up goroutines and
+// connections.
+type pendingMigrationsCheck struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+	// running is closed once the check in progress, if any, finishes.
+	running chan struct{}
+}
+
+func (c *pendingMigrationsCheck) check(ctx context.Context, runner *migration.Runner) error {
+	c.mu.Lock()
+	if c.running == nil && !c.checkedAt.IsZero() && time.Since(c.checkedAt) < pendingMigrationsTTL {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	if c.running == nil {
+		running := make(chan struct{})
+		c.running = running
+		go func() {
+			pending, err := runner.Pending()
+			if err == nil && len(pending) > 0 {
+				err = fmt.Errorf("pending: %s", strings.Join(pending, ", "))
+			}
+
+			c.mu.Lock()
+			c.checkedAt, c.err, c.running = time.Now(), err, nil
+			c.mu.Unlock()
+			close(running)
+		}()
+	}
+	running := c.running
+	c.mu.Unlock()
+
+	select {
+	case <-running:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type poolReport struct {
+	AcquiredConns           int32         `json:"acquiredConns"`
+	IdleConns               int32         `json:"idleConns"`
+	ConstructingConns       int32         `json:"constructingConns"`
+	TotalConns              int32         `json:"totalConns"`
+	MaxConns                int32         `json:"maxConns"`
+	AcquireCount            int64         `json:"acquireCount"`
+	AcquireDuration         time.Duration `json:"acquireDurationNs"`
+	EmptyAcquireCount       int64         `json:"emptyAcquireCount"`
+	CanceledAcquireCount    int64         `json:"canceledAcquireCount"`
+	NewConnsCount           int64         `json:"newConnsCount"`
+	MaxLifetimeDestroyCount int64         `json:"maxLifetimeDestroyCount"`
+	MaxIdleDestroyCount     int64         `json:"maxIdleDestroyCount"`
+}
+
+type dbReport struct {
+	Health    database.Health      `json:"health"`
+	Pool      poolReport           `json:"pool"`
+	Server    *database.ServerInfo `json:"server"`
+	Migration string               `json:"migration"`
+	Listening bool                 `json:"listening"`
+}
+
+// debugDB reports the state of the connection pool, the server and the schema
+// in detail, for operators rather than load balancers.
+func (s *server) debugDB(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	stat := s.db.Stat()
+	report := dbReport{
+		Pool: poolReport{
+			AcquiredConns:           stat.AcquiredConns(),
+			IdleConns:               stat.IdleConns(),
+			ConstructingConns:       stat.ConstructingConns(),
+			TotalConns:              stat.TotalConns(),
+			MaxConns:                stat.MaxConns(),
+			AcquireCount:            stat.AcquireCount(),
+			AcquireDuration:         stat.AcquireDuration(),
+			EmptyAcquireCount:       stat.EmptyAcquireCount(),
+			CanceledAcquireCount:    stat.CanceledAcquireCount(),
+			NewConnsCount:           stat.NewConnsCount(),
+			MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+			MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+		},
+		Health:    s.db.Health(),
+		Listening: s.db.ListeningForUserCacheInvalidations(),
+	}
+
+	info, err := database.GetServerInfo(ctx, s.db)
+	if err != nil {
+		jsend.Error(w, "error getting server info: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	report.Server = info
+
+	var current string
+	err = withTimeout(ctx, func() (err error) {
+		current, err = s.migrations.Current()
+		return err
+	})
+	if err != nil {
+		jsend.Error(w, "error getting current migration: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	report.Migration = current
+
+	jsend.Success(w, report, http.StatusOK)
+}
+
+// withTimeout runs f, giving up on it when ctx is done. f carries on in the
+// background, so it must not touch anything the caller goes on to use.
+func withTimeout(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
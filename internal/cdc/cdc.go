@@ -0,0 +1,464 @@
+// Package cdc streams every change made to the users and people tables, whether
+// or not it went through our stores, using Postgres logical decoding.
+//
+// It needs a server running with wal_level=logical and a role allowed to create
+// replication slots. Setup creates a publication and a pgoutput replication slot;
+// the slot remembers how far we have acknowledged, so a restarted Consumer picks
+// up with the first unacknowledged transaction. Delivery is at least once: events
+// that were sent but not acknowledged before a restart are sent again.
+//
+//	consumer := cdc.NewConsumer(db, cdc.DefaultSlotName, logger)
+//	if err := consumer.Setup(ctx); err != nil { ... }
+//	go consumer.Run(ctx)
+//
+//	for ev := range consumer.Users() {
+//		...
+//		consumer.Ack(ev.LSN)
+//	}
+//
+// Changes are read with pg_logical_slot_peek_binary_changes and acknowledged with
+// pg_replication_slot_advance, so no replication connection is needed.
+package cdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	DefaultSlotName       = "pgx_store_cdc"
+	DefaultPublication    = "pgx_store_cdc"
+	defaultPollInterval   = time.Second
+	defaultMaxChanges     = 1000
+	defaultChannelBufSize = 100
+	finalAdvanceTimeout   = 5 * time.Second
+)
+
+// LSN is a position in the write-ahead log.
+type LSN uint64
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// ParseLSN parses the textual form of a pg_lsn, e.g. 16/B374D848.
+func ParseLSN(s string) (LSN, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", s, err)
+	}
+	return LSN(uint64(hi)<<32 | uint64(lo)), nil
+}
+
+type Op string
+
+const (
+	OpInsert Op = "insert"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+// UserEvent is a change to a row of users. Old is only set for updates and
+// deletes and, unless the table's replica identity is FULL, only has the ID
+// filled in. New is nil for deletes.
+type UserEvent struct {
+	Op         Op
+	Old        *types.User
+	New        *types.User
+	CommitTime time.Time
+	// LSN is the end of the transaction the change belongs to. Pass it to Ack
+	// once the event has been processed.
+	LSN LSN
+}
+
+// PeopleEvent is a change to a row of people, see UserEvent.
+type PeopleEvent struct {
+	Op         Op
+	Old        *types.People
+	New        *types.People
+	CommitTime time.Time
+	LSN        LSN
+}
+
+// Consumer decodes changes from a replication slot into typed events.
+type Consumer struct {
+	db          database.DB
+	slot        string
+	publication string
+	logger      *slog.Logger
+	typeMap     *pgtype.Map
+
+	PollInterval time.Duration
+	// MaxChanges bounds how many changes are read per poll. Transactions are
+	// never split, so a single large transaction may exceed it.
+	MaxChanges int
+
+	users  chan UserEvent
+	people chan PeopleEvent
+
+	relations map[uint32]*relationMessage
+	// sent is the end of the last transaction whose events have been sent, so
+	// re-peeking changes that haven't been acknowledged yet doesn't send them twice.
+	sent LSN
+	// lastDelivered is the end of the last transaction that had any events.
+	lastDelivered LSN
+
+	mu       sync.Mutex
+	acked    LSN
+	advanced LSN
+}
+
+func NewConsumer(db database.DB, slot string, logger *slog.Logger) *Consumer {
+	return &Consumer{
+		db:           db,
+		slot:         slot,
+		publication:  DefaultPublication,
+		logger:       logger,
+		typeMap:      pgtype.NewMap(),
+		PollInterval: defaultPollInterval,
+		MaxChanges:   defaultMaxChanges,
+		users:        make(chan UserEvent, defaultChannelBufSize),
+		people:       make(chan PeopleEvent, defaultChannelBufSize),
+		relations:    map[uint32]*relationMessage{},
+	}
+}
+
+// Users returns the channel of changes to users. It is closed when Run returns.
+func (c *Consumer) Users() <-chan UserEvent { return c.users }
+
+// People returns the channel of changes to people. It is closed when Run returns.
+func (c *Consumer) People() <-chan PeopleEvent { return c.people }
+
+// Ack acknowledges every event up to and including the given LSN. Acknowledged
+// events are never delivered again, even after a restart.
+func (c *Consumer) Ack(lsn LSN) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lsn > c.acked {
+		c.acked = lsn
+	}
+}
+
+// Setup creates the publication and replication slot if they don't exist yet.
+func (c *Consumer) Setup(ctx context.Context) error {
+	var exists bool
+	if err := c.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)`, c.publication).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		// Publication names can't be bound as parameters.
+		if _, err := c.db.Exec(ctx, fmt.Sprintf(`CREATE PUBLICATION %q FOR TABLE users, people`, c.publication)); err != nil {
+			return fmt.Errorf("creating publication: %w", err)
+		}
+		c.logger.InfoContext(ctx, "created publication", "publication", c.publication)
+	}
+
+	if err := c.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, c.slot).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := c.db.Exec(ctx, `SELECT pg_create_logical_replication_slot($1, 'pgoutput')`, c.slot); err != nil {
+			return fmt.Errorf("creating replication slot: %w", err)
+		}
+		c.logger.InfoContext(ctx, "created replication slot", "slot", c.slot)
+	}
+
+	return c.db.QueryRow(ctx, `SELECT confirmed_flush_lsn::text FROM pg_replication_slots WHERE slot_name = $1`, c.slot).Scan(lsnScanner{&c.advanced})
+}
+
+// Drop removes the replication slot. Until it is dropped the slot makes Postgres
+// retain WAL for us, so it should be dropped once CDC is no longer wanted.
+func (c *Consumer) Drop(ctx context.Context) error {
+	_, err := c.db.Exec(ctx, `SELECT pg_drop_replication_slot($1)`, c.slot)
+	return err
+}
+
+// Run polls the slot for changes until the context is canceled, then closes the
+// event channels. Events acknowledged by the time it returns are not delivered
+// again by the next Consumer on the slot.
+func (c *Consumer) Run(ctx context.Context) error {
+	defer close(c.users)
+	defer close(c.people)
+
+	// Acknowledgements are otherwise only passed on to the slot at the start
+	// of a poll, so those made since the last one would be lost.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalAdvanceTimeout)
+		defer cancel()
+		if err := c.advance(ctx); err != nil {
+			c.logger.WarnContext(ctx, "error advancing replication slot on shutdown", "slot", c.slot, "error", err)
+		}
+	}()
+
+	for {
+		n, err := c.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if n > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+const peekChangesQuery = `
+SELECT lsn::text, data
+FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)
+`
+
+// poll advances the slot past acknowledged changes, then sends the events of
+// every transaction that hasn't been sent yet. It returns how many events it sent.
+func (c *Consumer) poll(ctx context.Context) (int, error) {
+	if err := c.advance(ctx); err != nil {
+		return 0, err
+	}
+
+	rows, err := c.db.Query(ctx, peekChangesQuery, c.slot, c.MaxChanges, c.publication)
+	if err != nil {
+		return 0, err
+	}
+
+	type change struct {
+		lsn  LSN
+		data []byte
+	}
+	var changes []change
+	for rows.Next() {
+		var ch change
+		if err := rows.Scan(lsnScanner{&ch.lsn}, &ch.data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		changes = append(changes, ch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var (
+		sent    int
+		pending []any
+	)
+	for _, ch := range changes {
+		msg, err := decodeMessage(ch.data)
+		if errors.Is(err, errUnsupportedMessage) {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		switch m := msg.(type) {
+		case *beginMessage:
+			pending = pending[:0]
+		case *relationMessage:
+			c.relations[m.ID] = m
+		case *changeMessage:
+			ev, err := c.event(m)
+			if err != nil {
+				return sent, err
+			}
+			if ev != nil {
+				pending = append(pending, ev)
+			}
+		case *commitMessage:
+			if m.EndLSN <= c.sent {
+				continue
+			}
+			for _, ev := range pending {
+				if err := c.send(ctx, ev, m); err != nil {
+					return sent, err
+				}
+				sent++
+			}
+			c.sent = m.EndLSN
+			if len(pending) > 0 {
+				c.lastDelivered = m.EndLSN
+			}
+			// Nothing to deliver, but the slot can still move past it once
+			// everything before it has been acknowledged.
+			if len(pending) == 0 {
+				c.ackIfCaughtUp(m.EndLSN)
+			}
+		}
+	}
+	return sent, nil
+}
+
+// ackIfCaughtUp acknowledges an empty transaction if every event sent before
+// it has already been acknowledged.
+func (c *Consumer) ackIfCaughtUp(lsn LSN) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.acked >= c.lastDelivered {
+		c.acked = lsn
+	}
+}
+
+func (c *Consumer) advance(ctx context.Context) error {
+	c.mu.Lock()
+	acked := c.acked
+	c.mu.Unlock()
+
+	if acked <= c.advanced {
+		return nil
+	}
+
+	if _, err := c.db.Exec(ctx, `SELECT pg_replication_slot_advance($1, $2::pg_lsn)`, c.slot, acked.String()); err != nil {
+		return fmt.Errorf("advancing replication slot: %w", err)
+	}
+	c.advanced = acked
+	return nil
+}
+
+func (c *Consumer) send(ctx context.Context, ev any, commit *commitMessage) error {
+	switch e := ev.(type) {
+	case *UserEvent:
+		e.LSN, e.CommitTime = commit.EndLSN, commit.CommitTime
+		select {
+		case c.users <- *e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case *PeopleEvent:
+		e.LSN, e.CommitTime = commit.EndLSN, commit.CommitTime
+		select {
+		case c.people <- *e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// event converts a decoded change into a *UserEvent or *PeopleEvent, or nil for
+// tables we don't publish events for.
+func (c *Consumer) event(m *changeMessage) (any, error) {
+	rel, ok := c.relations[m.RelationID]
+	if !ok {
+		return nil, fmt.Errorf("change for unknown relation %d", m.RelationID)
+	}
+
+	op := map[byte]Op{'I': OpInsert, 'U': OpUpdate, 'D': OpDelete}[m.Kind]
+	switch rel.Name {
+	case "users":
+		ev := &UserEvent{Op: op}
+		var err error
+		if m.Old != nil {
+			ev.Old = &types.User{}
+			err = c.decodeTuple(rel, m.Old, m.OldKey, userFields(ev.Old))
+		}
+		if m.New != nil && err == nil {
+			ev.New = &types.User{}
+			err = c.decodeTuple(rel, m.New, false, userFields(ev.New))
+		}
+		return ev, err
+	case "people":
+		ev := &PeopleEvent{Op: op}
+		var err error
+		if m.Old != nil {
+			ev.Old = &types.People{}
+			err = c.decodeTuple(rel, m.Old, m.OldKey, peopleFields(ev.Old))
+		}
+		if m.New != nil && err == nil {
+			ev.New = &types.People{}
+			err = c.decodeTuple(rel, m.New, false, peopleFields(ev.New))
+		}
+		return ev, err
+	}
+	return nil, nil
+}
+
+func userFields(u *types.User) map[string]any {
+	return map[string]any{
+		"id":         &u.ID,
+		"username":   &u.Username,
+		"email":      &u.Email,
+		"version":    &u.Version,
+		"created_at": &u.CreatedAt,
+		"deleted_at": &u.DeletedAt,
+	}
+}
+
+func peopleFields(p *types.People) map[string]any {
+	return map[string]any{
+		"id":         &p.ID,
+		"user_id":    &p.UserID,
+		"version":    &p.Version,
+		"deleted_at": &p.DeletedAt,
+	}
+}
+
+// decodeTuple scans the text-encoded columns of a row into the fields they map
+// to. Columns without a field are ignored, so adding a column doesn't break us,
+// as are the columns outside the key of a key-only tuple, which are all NULL.
+func (c *Consumer) decodeTuple(rel *relationMessage, tuple []tupleColumn, keyOnly bool, fields map[string]any) error {
+	if len(tuple) > len(rel.Columns) {
+		return fmt.Errorf("row of %s has %d columns, relation has %d", rel.Name, len(tuple), len(rel.Columns))
+	}
+
+	for i, col := range tuple {
+		meta := rel.Columns[i]
+		dst, ok := fields[meta.Name]
+		if !ok || col.Kind == 'u' || keyOnly && !meta.Key {
+			continue
+		}
+
+		var src []byte
+		if col.Kind != 'n' {
+			src = col.Data
+		}
+		if err := c.typeMap.Scan(meta.TypeOID, pgtype.TextFormatCode, src, dst); err != nil {
+			return fmt.Errorf("decoding %s.%s: %w", rel.Name, meta.Name, err)
+		}
+	}
+	return nil
+}
+
+// lsnScanner scans the text form of a pg_lsn.
+type lsnScanner struct {
+	lsn *LSN
+}
+
+func (s lsnScanner) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	case nil:
+		*s.lsn = 0
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into LSN", src)
+	}
+
+	lsn, err := ParseLSN(text)
+	if err != nil {
+		return err
+	}
+	*s.lsn = lsn
+	return nil
+}
@@ -0,0 +1,170 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbtest"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var testLogger = dbtest.Logger
+
+// TestKeyTupleEvent checks that a delete from a table with the default replica
+// identity, for which pgoutput only sends the key, decodes to an event with
+// the ID filled in.
+func TestKeyTupleEvent(t *testing.T) {
+	c := NewConsumer(nil, DefaultSlotName, testLogger)
+	c.relations[1] = &relationMessage{
+		ID:   1,
+		Name: "users",
+		Columns: []relationColumn{
+			{Name: "id", TypeOID: pgtype.UUIDOID, Key: true},
+			{Name: "username", TypeOID: pgtype.TextOID},
+			{Name: "email", TypeOID: pgtype.TextOID},
+			{Name: "version", TypeOID: pgtype.Int4OID},
+		},
+	}
+
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	ev, err := c.event(&changeMessage{
+		Kind:       'D',
+		RelationID: 1,
+		Old:        []tupleColumn{{Kind: 't', Data: []byte(id)}, {Kind: 'n'}, {Kind: 'n'}, {Kind: 'n'}},
+		OldKey:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userEv := ev.(*UserEvent)
+	if userEv.Op != OpDelete || userEv.New != nil {
+		t.Errorf("got %+v, want a delete without a new row", userEv)
+	}
+	if userEv.Old == nil || userEv.Old.ID != id {
+		t.Errorf("got old row %+v, want ID %s", userEv.Old, id)
+	}
+}
+
+// TestConsumer runs against the test database (see dbtest), whose server must
+// be running with wal_level=logical. It migrates the database, and creates and
+// drops a replication slot of its own.
+func TestConsumer(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, ctx)
+
+	const slot = "pgx_store_cdc_test"
+	dropSlot := func() {
+		_, err := db.Exec(ctx, `SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1`, slot)
+		if err != nil {
+			t.Errorf("dropping replication slot: %v", err)
+		}
+	}
+	dropSlot()
+	t.Cleanup(dropSlot)
+
+	start := func() (c *Consumer, stop func()) {
+		c = NewConsumer(db, slot, testLogger)
+		c.PollInterval = 10 * time.Millisecond
+		if err := c.Setup(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- c.Run(ctx) }()
+		return c, func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Run: %v", err)
+			}
+		}
+	}
+
+	suffix := time.Now().UnixNano()
+	username, email := fmt.Sprintf("cdc-%d", suffix), fmt.Sprintf("cdc-%d@example.com", suffix)
+	newEmail := fmt.Sprintf("cdc-%d@example.org", suffix)
+
+	consumer, stop := start()
+
+	user, err := db.Users().Create(ctx, email, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted := nextUserEvent(t, consumer, user.ID)
+	if inserted.Op != OpInsert || inserted.New == nil || inserted.New.Username != username || inserted.New.Email != email {
+		t.Errorf("got %+v, want an insert of %s", inserted, username)
+	}
+
+	if _, err := db.Users().Update(ctx, user.ID, database.UserUpdate{Email: &newEmail}); err != nil {
+		t.Fatal(err)
+	}
+	updated := nextUserEvent(t, consumer, user.ID)
+	if updated.Op != OpUpdate || updated.New == nil || updated.New.Email != newEmail {
+		t.Errorf("got %+v, want an update to %s", updated, newEmail)
+	}
+	if updated.LSN <= inserted.LSN {
+		t.Errorf("update LSN %s is not after insert LSN %s", updated.LSN, inserted.LSN)
+	}
+	consumer.Ack(updated.LSN)
+
+	if err := db.Users().Purge(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	deleted := nextUserEvent(t, consumer, user.ID)
+	if deleted.Op != OpDelete || deleted.Old == nil || deleted.Old.ID != user.ID || deleted.New != nil {
+		t.Errorf("got %+v, want a delete of %s", deleted, user.ID)
+	}
+
+	// The delete is left unacknowledged, so after a restart it is delivered
+	// again, but the acknowledged insert and update are not.
+	stop()
+	consumer, stop = start()
+	defer stop()
+
+	redelivered := nextUserEvent(t, consumer, user.ID)
+	if redelivered.Op != OpDelete || redelivered.LSN != deleted.LSN {
+		t.Errorf("got %s at %s after restarting, want only the unacknowledged delete at %s", redelivered.Op, redelivered.LSN, deleted.LSN)
+	}
+}
+
+// openTestDB connects to the test database and applies every migration.
+func openTestDB(t *testing.T, ctx context.Context) database.DB {
+	t.Helper()
+
+	db, err := database.New(ctx, testLogger, dbtest.Config(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+	dbtest.Migrate(t, sdb)
+	return db
+}
+
+// nextUserEvent returns the next event for the user with the given ID,
+// skipping events for other users, which other writers to the database may be
+// making.
+func nextUserEvent(t *testing.T, c *Consumer, id string) UserEvent {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-c.Users():
+			if !ok {
+				t.Fatal("consumer stopped")
+			}
+			if (ev.New != nil && ev.New.ID == id) || (ev.Old != nil && ev.Old.ID == id) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no event for user %s", id)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// This file decodes the subset of the pgoutput logical replication protocol
+// (version 1) that we need: transactions, relations and row changes. See
+// https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html
+
+// postgresEpoch is the zero point of timestamps in the replication protocol.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type beginMessage struct {
+	FinalLSN   LSN
+	CommitTime time.Time
+	Xid        uint32
+}
+
+type commitMessage struct {
+	CommitLSN  LSN
+	EndLSN     LSN
+	CommitTime time.Time
+}
+
+type relationColumn struct {
+	Name    string
+	TypeOID uint32
+	// Key is set for the columns of the table's replica identity, the only
+	// ones an old key tuple has values for.
+	Key bool
+}
+
+type relationMessage struct {
+	ID        uint32
+	Namespace string
+	Name      string
+	Columns   []relationColumn
+}
+
+// tupleColumn is one column of a row as sent by pgoutput. Unchanged TOASTed
+// values and NULLs carry no data.
+type tupleColumn struct {
+	Kind byte // 'n' for NULL, 'u' for unchanged TOAST, 't' for text
+	Data []byte
+}
+
+type changeMessage struct {
+	Kind       byte // 'I', 'U' or 'D'
+	RelationID uint32
+	Old        []tupleColumn
+	// OldKey is set if Old is only the replica identity, with every other
+	// column NULL, rather than the whole row.
+	OldKey bool
+	New    []tupleColumn
+}
+
+var errUnsupportedMessage = errors.New("unsupported pgoutput message")
+
+// decodeMessage decodes a single pgoutput message into one of the *Message types
+// above. Message types we don't care about return errUnsupportedMessage.
+func decodeMessage(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty pgoutput message")
+	}
+
+	r := &reader{buf: data[1:]}
+	var msg any
+	switch data[0] {
+	case 'B':
+		msg = &beginMessage{
+			FinalLSN:   LSN(r.uint64()),
+			CommitTime: r.timestamp(),
+			Xid:        r.uint32(),
+		}
+	case 'C':
+		r.byte() // flags, currently unused
+		msg = &commitMessage{
+			CommitLSN:  LSN(r.uint64()),
+			EndLSN:     LSN(r.uint64()),
+			CommitTime: r.timestamp(),
+		}
+	case 'R':
+		rel := &relationMessage{ID: r.uint32(), Namespace: r.string(), Name: r.string()}
+		r.byte() // replica identity setting
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			flags := r.byte()
+			col := relationColumn{Key: flags&1 != 0, Name: r.string(), TypeOID: r.uint32()}
+			r.uint32() // type modifier
+			rel.Columns = append(rel.Columns, col)
+		}
+		msg = rel
+	case 'I':
+		change := &changeMessage{Kind: 'I', RelationID: r.uint32()}
+		if tag := r.byte(); tag != 'N' {
+			return nil, fmt.Errorf("unexpected tuple tag %q in insert", tag)
+		}
+		change.New = r.tuple()
+		msg = change
+	case 'U':
+		change := &changeMessage{Kind: 'U', RelationID: r.uint32()}
+		tag := r.byte()
+		if tag == 'K' || tag == 'O' {
+			change.Old, change.OldKey = r.tuple(), tag == 'K'
+			tag = r.byte()
+		}
+		if tag != 'N' {
+			return nil, fmt.Errorf("unexpected tuple tag %q in update", tag)
+		}
+		change.New = r.tuple()
+		msg = change
+	case 'D':
+		change := &changeMessage{Kind: 'D', RelationID: r.uint32()}
+		tag := r.byte()
+		if tag != 'K' && tag != 'O' {
+			return nil, fmt.Errorf("unexpected tuple tag %q in delete", tag)
+		}
+		change.Old, change.OldKey = r.tuple(), tag == 'K'
+		msg = change
+	default:
+		return nil, errUnsupportedMessage
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("decoding pgoutput message %q: %w", data[0], r.err)
+	}
+	return msg, nil
+}
+
+// reader reads big-endian protocol values, recording the first error rather
+// than returning it from every call.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errors.New("message truncated")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) timestamp() time.Time {
+	micros := int64(r.uint64())
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = errors.New("unterminated string")
+	return ""
+}
+
+func (r *reader) tuple() []tupleColumn {
+	n := int(r.uint16())
+	cols := make([]tupleColumn, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		col := tupleColumn{Kind: r.byte()}
+		if col.Kind == 't' || col.Kind == 'b' {
+			col.Data = r.next(int(r.uint32()))
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
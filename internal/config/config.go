@@ -0,0 +1,200 @@
+// Package config loads the service's settings.
+//
+// Every setting has a default and can be overridden, in increasing order of
+// precedence, by a JSON file, an environment variable and a command-line flag.
+// The file is named by the -config flag or the PGX_STORE_CONFIG environment
+// variable and uses the json field names below, with durations written as Go
+// duration strings, e.g.
+//
+//	{"database": {"host": "db.internal", "maxConns": 20}, "http": {"addr": ":8080", "writeTimeout": "1m"}}
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Database   Database   `json:"database"`
+	HTTP       HTTP       `json:"http"`
+	Log        Log        `json:"log"`
+	Migrations Migrations `json:"migrations"`
+}
+
+type Database struct {
+	Host            string `json:"host" env:"PGHOST" flag:"db-host" usage:"database server host"`
+	Port            int    `json:"port" env:"PGPORT" flag:"db-port" usage:"database server port"`
+	User            string `json:"user" env:"PGUSER" flag:"db-user" usage:"database user"`
+	Password        Secret `json:"password" env:"PGPASSWORD" usage:"database password (environment or file only)"`
+	Name            string `json:"name" env:"PGDATABASE" flag:"db-name" usage:"database name"`
+	ApplicationName string `json:"applicationName" env:"PGAPPNAME" flag:"db-application-name" usage:"application_name reported to the server"`
+
+	// PasswordCommand, PasswordFile, Password and Passfile are where the
+	// password comes from, in that order of preference. The password is
+	// fetched again once it has been cached for CredentialsTTL or the server
+	// rejects it, so that credentials can be rotated without a restart.
+	PasswordCommand string        `json:"passwordCommand" env:"DB_PASSWORD_COMMAND" flag:"db-password-command" usage:"shell command that prints the database password"`
+	PasswordFile    string        `json:"passwordFile" env:"DB_PASSWORD_FILE" flag:"db-password-file" usage:"file holding the database password"`
+	Passfile        string        `json:"passfile" env:"PGPASSFILE" flag:"db-passfile" usage:"pgpass file to look the password up in when none is set (default ~/.pgpass)"`
+	CredentialsTTL  time.Duration `json:"credentialsTTL" env:"DB_CREDENTIALS_TTL" flag:"db-credentials-ttl" usage:"how long the database password is cached before being fetched again"`
+
+	SSLMode       string `json:"sslMode" env:"PGSSLMODE" flag:"db-sslmode" usage:"disable, allow, prefer, require, verify-ca or verify-full"`
+	SSLRootCert   string `json:"sslRootCert" env:"PGSSLROOTCERT" flag:"db-sslrootcert" usage:"file of CA certificates to verify the server with"`
+	SSLCert       string `json:"sslCert" env:"PGSSLCERT" flag:"db-sslcert" usage:"client certificate file"`
+	SSLKey        string `json:"sslKey" env:"PGSSLKEY" flag:"db-sslkey" usage:"client private key file"`
+	SSLServerName string `json:"sslServerName" env:"PGSSLSERVERNAME" flag:"db-sslservername" usage:"name to verify the server certificate against, if not the host"`
+
+	MaxConns          int32         `json:"maxConns" env:"DB_MAX_CONNS" flag:"db-max-conns" usage:"maximum pool size"`
+	MinConns          int32         `json:"minConns" env:"DB_MIN_CONNS" flag:"db-min-conns" usage:"connections the pool keeps open when idle"`
+	MaxConnLifetime   time.Duration `json:"maxConnLifetime" env:"DB_MAX_CONN_LIFETIME" flag:"db-max-conn-lifetime" usage:"how long a connection is used before being replaced"`
+	MaxConnIdleTime   time.Duration `json:"maxConnIdleTime" env:"DB_MAX_CONN_IDLE_TIME" flag:"db-max-conn-idle-time" usage:"how long an idle connection is kept"`
+	HealthCheckPeriod time.Duration `json:"healthCheckPeriod" env:"DB_HEALTH_CHECK_PERIOD" flag:"db-health-check-period" usage:"how often idle connections are checked"`
+	ConnectTimeout    time.Duration `json:"connectTimeout" env:"PGCONNECT_TIMEOUT" flag:"db-connect-timeout" usage:"timeout for establishing a connection"`
+	StartupTimeout    time.Duration `json:"startupTimeout" env:"DB_STARTUP_TIMEOUT" flag:"db-startup-timeout" usage:"how long to keep retrying the database at startup"`
+
+	// MaxConnLifetimeJitter spreads out the replacement of connections, both
+	// those reaching MaxConnLifetime and those opened with credentials that
+	// have since been rotated, so that they aren't all reopened at once.
+	MaxConnLifetimeJitter time.Duration `json:"maxConnLifetimeJitter" env:"DB_MAX_CONN_LIFETIME_JITTER" flag:"db-max-conn-lifetime-jitter" usage:"random extra time over which connections due for replacement are replaced"`
+}
+
+type HTTP struct {
+	Addr              string        `json:"addr" env:"HTTP_ADDR" flag:"http-addr" usage:"address to listen on"`
+	ReadTimeout       time.Duration `json:"readTimeout" env:"HTTP_READ_TIMEOUT" flag:"http-read-timeout" usage:"timeout for reading a whole request"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" env:"HTTP_READ_HEADER_TIMEOUT" flag:"http-read-header-timeout" usage:"timeout for reading request headers"`
+	WriteTimeout      time.Duration `json:"writeTimeout" env:"HTTP_WRITE_TIMEOUT" flag:"http-write-timeout" usage:"timeout for writing a response"`
+	IdleTimeout       time.Duration `json:"idleTimeout" env:"HTTP_IDLE_TIMEOUT" flag:"http-idle-timeout" usage:"how long idle keep-alive connections are kept"`
+	ShutdownTimeout   time.Duration `json:"shutdownTimeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"http-shutdown-timeout" usage:"how long graceful shutdown may take before exiting with an error"`
+	// DrainDelay is how long we keep accepting requests after readiness
+	// starts failing on shutdown, to give load balancers time to notice and
+	// stop sending us new ones. It counts towards ShutdownTimeout.
+	DrainDelay time.Duration `json:"drainDelay" env:"HTTP_DRAIN_DELAY" flag:"http-drain-delay" usage:"how long to keep serving after readiness fails on shutdown"`
+
+	// CursorKey signs the pagination cursors handed to clients. Without one a
+	// random key is used, so cursors stop working on restart and aren't
+	// accepted by other instances.
+	CursorKey Secret `json:"cursorKey" env:"HTTP_CURSOR_KEY" usage:"key pagination cursors are signed with (environment or file only)"`
+	// AdminToken is the bearer token operator endpoints, such as /debug/db
+	// and /audit, require. Without one they are disabled.
+	AdminToken Secret `json:"adminToken" env:"HTTP_ADMIN_TOKEN" usage:"bearer token for the operator endpoints (environment or file only)"`
+}
+
+type Log struct {
+	Level string `json:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+}
+
+type Migrations struct {
+	// Mode is what happens to pending migrations at startup: "apply" applies
+	// them and "check" refuses to start.
+	Mode                 string        `json:"mode" env:"MIGRATIONS" flag:"migrations" usage:"what to do with pending migrations at startup: apply or check"`
+	LockTimeout          time.Duration `json:"lockTimeout" env:"MIGRATIONS_LOCK_TIMEOUT" flag:"migrations-lock-timeout" usage:"how long to wait for another instance to finish migrating"`
+	StatementLockTimeout time.Duration `json:"statementLockTimeout" env:"MIGRATIONS_STATEMENT_LOCK_TIMEOUT" flag:"migrations-statement-lock-timeout" usage:"lock_timeout migrations run with"`
+}
+
+// Default returns the settings used when nothing overrides them, which suit a
+// local development database.
+func Default() Config {
+	return Config{
+		Database: Database{
+			Host:                  "localhost",
+			Port:                  5432,
+			User:                  "sourcegraph",
+			Password:              "sourcegraph",
+			Name:                  "pgx-test",
+			ApplicationName:       "pgx-test",
+			SSLMode:               "prefer",
+			MaxConns:              4,
+			MinConns:              1,
+			CredentialsTTL:        5 * time.Minute,
+			MaxConnLifetime:       time.Hour,
+			MaxConnLifetimeJitter: 5 * time.Minute,
+			MaxConnIdleTime:       30 * time.Minute,
+			HealthCheckPeriod:     time.Minute,
+			ConnectTimeout:        5 * time.Second,
+			StartupTimeout:        time.Minute,
+		},
+		HTTP: HTTP{
+			Addr:              ":3000",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			DrainDelay:        5 * time.Second,
+		},
+		Log: Log{
+			Level: "info",
+		},
+		Migrations: Migrations{
+			Mode:                 "apply",
+			LockTimeout:          5 * time.Minute,
+			StatementLockTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// Secret is a string that is redacted when printed or encoded, so that it
+// can't leak into logs or config print output by accident.
+type Secret string
+
+const redacted = "REDACTED"
+
+// Value returns the secret itself.
+func (s Secret) Value() string { return string(s) }
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// DSN returns the connection string for the database settings.
+func (d Database) DSN() string {
+	params := []string{
+		"host=" + quoteDSNValue(d.Host),
+		"port=" + strconv.Itoa(d.Port),
+		"user=" + quoteDSNValue(d.User),
+		"dbname=" + quoteDSNValue(d.Name),
+		"sslmode=" + quoteDSNValue(d.SSLMode),
+	}
+	optional := []struct{ key, value string }{
+		{"password", d.Password.Value()},
+		{"application_name", d.ApplicationName},
+		{"sslrootcert", d.SSLRootCert},
+		{"sslcert", d.SSLCert},
+		{"sslkey", d.SSLKey},
+	}
+	for _, p := range optional {
+		if p.value != "" {
+			params = append(params, p.key+"="+quoteDSNValue(p.value))
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// String describes the database without its password.
+func (d Database) String() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(d.User),
+		Host:     fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:     d.Name,
+		RawQuery: "sslmode=" + url.QueryEscape(d.SSLMode),
+	}
+	return u.String()
+}
+
+func quoteDSNValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileEnv names the config file when the -config flag isn't given.
+const fileEnv = "PGX_STORE_CONFIG"
+
+// setting is a single leaf field of Config along with the ways to set it.
+type setting struct {
+	path  string
+	env   string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+func settings(c *Config) []setting {
+	var all []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := prefix + strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.Type.Kind() == reflect.Struct {
+				walk(path+".", v.Field(i))
+				continue
+			}
+			all = append(all, setting{
+				path:  path,
+				env:   f.Tag.Get("env"),
+				flag:  f.Tag.Get("flag"),
+				usage: f.Tag.Get("usage"),
+				value: v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return all
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (s setting) set(raw string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(raw)
+	case s.value.Kind() == reflect.Int || s.value.Kind() == reflect.Int32:
+		n, err := strconv.ParseInt(raw, 10, s.value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		s.value.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// Load returns the config built from the defaults, the config file, the
+// environment and the flags at the start of args, in that order of
+// precedence. Flag parsing stops at the first argument that isn't a flag, and
+// the arguments from there on are returned, so that subcommands can follow the
+// flags. Every problem found is reported in a single error.
+func Load(args []string) (*Config, []string, error) {
+	c := Default()
+	all := settings(&c)
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := fs.String("config", "", "JSON config file (or $"+fileEnv+")")
+	flags := map[string]*string{}
+	for _, s := range all {
+		if s.flag == "" {
+			continue
+		}
+		usage := s.usage
+		if s.env != "" {
+			usage += " ($" + s.env + ")"
+		}
+		flags[s.flag] = fs.String(s.flag, fmt.Sprint(s.value.Interface()), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv(fileEnv)
+	}
+	var problems []string
+	if *configFile != "" {
+		fileProblems, err := readFile(*configFile, all)
+		if err != nil {
+			return nil, nil, err
+		}
+		problems = append(problems, fileProblems...)
+	}
+
+	for _, s := range all {
+		if s.env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range all {
+		if set[s.flag] {
+			if err := s.set(*flags[s.flag]); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", s.flag, err))
+			}
+		}
+	}
+
+	problems = append(problems, c.problems()...)
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("invalid config:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return &c, fs.Args(), nil
+}
+
+var (
+	sslModes  = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	logLevels = []string{"debug", "info", "warn", "error"}
+	modes     = []string{"apply", "check"}
+)
+
+// problems lists everything wrong with the config.
+func (c *Config) problems() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	d := c.Database
+	check(d.Host != "", "database.host is required")
+	check(d.Port > 0 && d.Port < 65536, "database.port must be between 1 and 65535, got %d", d.Port)
+	check(d.User != "", "database.user is required")
+	check(d.Name != "", "database.name is required")
+	check(oneOf(d.SSLMode, sslModes), "database.sslMode must be one of %s, got %q", strings.Join(sslModes, ", "), d.SSLMode)
+	check((d.SSLCert == "") == (d.SSLKey == ""), "database.sslCert and database.sslKey must be set together")
+	check(d.SSLRootCert == "" || oneOf(d.SSLMode, []string{"require", "verify-ca", "verify-full"}), "database.sslRootCert is only used with sslMode require, verify-ca or verify-full")
+	check(d.MaxConns > 0, "database.maxConns must be positive, got %d", d.MaxConns)
+	check(d.MinConns >= 0 && d.MinConns <= d.MaxConns, "database.minConns must be between 0 and database.maxConns, got %d", d.MinConns)
+	check(d.CredentialsTTL > 0, "database.credentialsTTL must be positive")
+	check(d.MaxConnLifetime > 0, "database.maxConnLifetime must be positive")
+	check(d.MaxConnLifetimeJitter >= 0, "database.maxConnLifetimeJitter must not be negative")
+	check(d.MaxConnIdleTime > 0, "database.maxConnIdleTime must be positive")
+	check(d.HealthCheckPeriod > 0, "database.healthCheckPeriod must be positive")
+	check(d.ConnectTimeout > 0, "database.connectTimeout must be positive")
+	check(d.StartupTimeout > 0, "database.startupTimeout must be positive")
+
+	h := c.HTTP
+	check(h.Addr != "", "http.addr is required")
+	check(h.ReadTimeout >= 0, "http.readTimeout must not be negative")
+	check(h.ReadHeaderTimeout >= 0, "http.readHeaderTimeout must not be negative")
+	check(h.WriteTimeout >= 0, "http.writeTimeout must not be negative")
+	check(h.IdleTimeout >= 0, "http.idleTimeout must not be negative")
+	check(h.ShutdownTimeout > 0, "http.shutdownTimeout must be positive")
+	check(h.DrainDelay >= 0 && h.DrainDelay < h.ShutdownTimeout, "http.drainDelay must be between 0 and http.shutdownTimeout, got %s", h.DrainDelay)
+	check(h.CursorKey == "" || len(h.CursorKey) >= 32, "http.cursorKey must be at least 32 bytes")
+	check(h.AdminToken == "" || len(h.AdminToken) >= 32, "http.adminToken must be at least 32 bytes")
+
+	check(oneOf(c.Log.Level, logLevels), "log.level must be one of %s, got %q", strings.Join(logLevels, ", "), c.Log.Level)
+
+	m := c.Migrations
+	check(oneOf(m.Mode, modes), "migrations.mode must be one of %s, got %q", strings.Join(modes, ", "), m.Mode)
+	check(m.LockTimeout > 0, "migrations.lockTimeout must be positive")
+	check(m.StatementLockTimeout > 0, "migrations.statementLockTimeout must be positive")
+
+	return problems
+}
+
+func oneOf(v string, values []string) bool {
+	for _, value := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readFile overlays the settings in the named JSON file, returning a problem
+// for each setting that is unknown or has an invalid value.
+func readFile(path string, all []setting) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", path, err)
+	}
+
+	byPath := make(map[string]setting, len(all))
+	for _, s := range all {
+		byPath[s.path] = s
+	}
+
+	var problems []string
+	for section, values := range file {
+		for key, raw := range values {
+			path := section + "." + key
+			s, ok := byPath[path]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting", path))
+				continue
+			}
+
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			switch v := value.(type) {
+			case string:
+				err = s.set(v)
+			case float64:
+				err = s.set(strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				err = fmt.Errorf("must be a string or a number")
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// Print writes the config as JSON in the format the config file uses, with
+// secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	out := map[string]map[string]any{}
+	for _, s := range settings(c) {
+		section, key, _ := strings.Cut(s.path, ".")
+		if out[section] == nil {
+			out[section] = map[string]any{}
+		}
+		switch v := s.value.Interface().(type) {
+		case time.Duration, Secret:
+			out[section][key] = fmt.Sprint(v)
+		default:
+			out[section][key] = v
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
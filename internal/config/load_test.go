@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes contents to a config file and returns its path.
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv(fileEnv, "")
+	file := writeConfigFile(t, `{
+		"http": {"addr": ":1000", "writeTimeout": "1m", "idleTimeout": "3m"},
+		"database": {"maxConns": 20, "minConns": 2}
+	}`)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    string
+		wantMax int32
+	}{
+		{name: "default", want: ":3000", wantMax: 4},
+		{name: "file", args: []string{"-config", file}, want: ":1000", wantMax: 20},
+		{name: "file from the environment", env: map[string]string{fileEnv: file}, want: ":1000", wantMax: 20},
+		{name: "environment over file", env: map[string]string{"HTTP_ADDR": ":2000"}, args: []string{"-config", file}, want: ":2000", wantMax: 20},
+		{
+			name:    "flag over environment",
+			env:     map[string]string{"HTTP_ADDR": ":2000", "DB_MAX_CONNS": "30"},
+			args:    []string{"-config", file, "-http-addr", ":4000"},
+			want:    ":4000",
+			wantMax: 30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, rest, err := Load(append(tt.args, "serve", "-not-a-flag"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.HTTP.Addr != tt.want || c.Database.MaxConns != tt.wantMax {
+				t.Errorf("got addr %q and maxConns %d, want %q and %d", c.HTTP.Addr, c.Database.MaxConns, tt.want, tt.wantMax)
+			}
+			if got := strings.Join(rest, " "); got != "serve -not-a-flag" {
+				t.Errorf("got remaining args %q, want the subcommand and its flags", got)
+			}
+		})
+	}
+
+	t.Run("untouched settings", func(t *testing.T) {
+		c, _, err := Load([]string{"-config", file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.HTTP.WriteTimeout != time.Minute || c.HTTP.ReadTimeout != Default().HTTP.ReadTimeout {
+			t.Errorf("got writeTimeout %s and readTimeout %s, want the file's and the default", c.HTTP.WriteTimeout, c.HTTP.ReadTimeout)
+		}
+	})
+}
+
+func TestLoadProblems(t *testing.T) {
+	t.Setenv(fileEnv, "")
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{
+			name: "unknown settings",
+			file: `{"http": {"adr": ":1000"}, "cache": {"size": 1}}`,
+			want: []string{"cache.size: unknown setting", "http.adr: unknown setting"},
+		},
+		{
+			name: "invalid values",
+			file: `{"http": {"writeTimeout": 30}, "database": {"port": "five"}, "log": {"level": true}}`,
+			want: []string{
+				`database.port: invalid integer "five"`,
+				`http.writeTimeout: invalid duration "30"`,
+				"log.level: must be a string or a number",
+			},
+		},
+		{
+			name: "every source",
+			file: `{"database": {"host": ""}}`,
+			env:  map[string]string{"DB_MAX_CONNS": "many", "LOG_LEVEL": "loud"},
+			args: []string{"-http-shutdown-timeout", "soon", "-db-sslmode", "maybe"},
+			want: []string{
+				"DB_MAX_CONNS: invalid integer \"many\"",
+				`-http-shutdown-timeout: invalid duration "soon"`,
+				"database.host is required",
+				`database.sslMode must be one of disable, allow, prefer, require, verify-ca, verify-full, got "maybe"`,
+				`log.level must be one of debug, info, warn, error, got "loud"`,
+			},
+		},
+		{
+			name: "related settings",
+			args: []string{"-db-max-conns", "2", "-db-min-conns", "3", "-db-sslcert", "client.crt", "-http-drain-delay", "1m"},
+			want: []string{
+				"database.sslCert and database.sslKey must be set together",
+				"database.minConns must be between 0 and database.maxConns, got 3",
+				"http.drainDelay must be between 0 and http.shutdownTimeout, got 1m0s",
+			},
+		},
+		{
+			name: "short secrets",
+			env:  map[string]string{"HTTP_CURSOR_KEY": "short", "HTTP_ADMIN_TOKEN": "short"},
+			want: []string{"http.cursorKey must be at least 32 bytes", "http.adminToken must be at least 32 bytes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			_, _, err := Load(args)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			got := strings.Split(strings.TrimPrefix(err.Error(), "invalid config:\n\t"), "\n\t")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got problems\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	const password, key, token = "hunter2-hunter2", "0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210"
+
+	c := Default()
+	c.Database.Password = password
+	c.HTTP.CursorKey = key
+	c.HTTP.AdminToken = token
+	c.HTTP.WriteTimeout = 90 * time.Second
+
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, secret := range []string{password, key, token} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed config contains the secret %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{`"password": "REDACTED"`, `"cursorKey": "REDACTED"`, `"writeTimeout": "1m30s"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("printed config doesn't contain %s:\n%s", want, out)
+		}
+	}
+}
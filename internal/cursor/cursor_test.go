@@ -0,0 +1,93 @@
+package cursor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+type position struct {
+	Name string `json:"n"`
+	ID   int    `json:"i"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := NewCodec([]byte("key"))
+	want := position{Name: "alice", ID: 7}
+
+	s, err := c.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got position
+	if err := c.Decode(s, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	c := NewCodec([]byte("key"))
+	valid, err := c.Encode(position{Name: "alice", ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered changes the payload, leaving the signature as it was.
+	tampered := bytes.Replace(raw, []byte("alice"), []byte("mallo"), 1)
+	otherKey, err := NewCodec([]byte("other key")).Encode(position{Name: "alice", ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownField, err := c.Encode(map[string]any{"n": "alice", "x": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "tampered", cursor: base64.RawURLEncoding.EncodeToString(tampered)},
+		{name: "signed with another key", cursor: otherKey},
+		{name: "unsigned", cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"n":"alice","i":7}`))},
+		{name: "truncated", cursor: valid[:len(valid)-4]},
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "empty", cursor: ""},
+		{name: "unknown field", cursor: unknownField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got position
+			if err := c.Decode(tt.cursor, &got); !IsInvalidCursorErr(err) {
+				t.Errorf("got %v, want an invalid cursor error", err)
+			}
+		})
+	}
+}
+
+func TestRandomCodecs(t *testing.T) {
+	a, err := NewRandomCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRandomCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := a.Encode(position{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got position
+	if err := b.Decode(s, &got); !IsInvalidCursorErr(err) {
+		t.Errorf("got %v from another random codec, want an invalid cursor error", err)
+	}
+}
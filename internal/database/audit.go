@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/keegancsmith/sqlf"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+const (
+	AuditOperationCreate  = "create"
+	AuditOperationUpdate  = "update"
+	AuditOperationDelete  = "delete"
+	AuditOperationRestore = "restore"
+	AuditOperationPurge   = "purge"
+)
+
+type auditContextKey int
+
+const (
+	actorKey auditContextKey = iota
+	claimedActorKey
+	requestIDKey
+)
+
+// WithActor returns a context recording who is responsible for the mutations made
+// with it. The actor is written to every audit entry, so it must have been
+// authenticated; see WithClaimedActor otherwise.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// WithClaimedActor returns a context recording who the caller says is
+// responsible for the mutations made with it, without that having been
+// verified. It is written to audit entries apart from the actor.
+func WithClaimedActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, claimedActorKey, actor)
+}
+
+// WithRequestID returns a context recording the ID of the request the mutations
+// made with it belong to.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey).(string)
+	return actor
+}
+
+func claimedActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(claimedActorKey).(string)
+	return actor
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+var auditColumns = []*sqlf.Query{
+	sqlf.Sprintf("audit_log.id"),
+	sqlf.Sprintf("audit_log.table_name"),
+	sqlf.Sprintf("audit_log.record_id"),
+	sqlf.Sprintf("audit_log.operation"),
+	sqlf.Sprintf("audit_log.old_data"),
+	sqlf.Sprintf("audit_log.new_data"),
+	sqlf.Sprintf("audit_log.actor"),
+	sqlf.Sprintf("audit_log.claimed_actor"),
+	sqlf.Sprintf("audit_log.request_id"),
+	sqlf.Sprintf("audit_log.created_at"),
+}
+
+var auditInsertColumns = []*sqlf.Query{
+	sqlf.Sprintf("table_name"),
+	sqlf.Sprintf("record_id"),
+	sqlf.Sprintf("operation"),
+	sqlf.Sprintf("old_data"),
+	sqlf.Sprintf("new_data"),
+	sqlf.Sprintf("actor"),
+	sqlf.Sprintf("claimed_actor"),
+	sqlf.Sprintf("request_id"),
+}
+
+type AuditStore interface {
+	// Record writes an audit entry for a mutation of the given row, taking the
+	// actor, claimed actor and request ID from the context. oldRow and newRow are stored as JSON
+	// and may be nil. Stores call this on the same handle as the mutation so both
+	// are committed or rolled back together.
+	Record(ctx context.Context, table, recordID, operation string, oldRow, newRow any) error
+	List(ctx context.Context, opts ListAuditArgs) ([]*types.AuditEntry, error)
+}
+
+type ListAuditArgs struct {
+	Limit  int
+	Offset int
+
+	Table        string
+	RecordID     string
+	Operation    string
+	Actor        string
+	ClaimedActor string
+	RequestID    string
+	// Since and Until restrict entries to those created in [Since, Until), when set.
+	Since time.Time
+	Until time.Time
+}
+
+func (a ListAuditArgs) conds() []*sqlf.Query {
+	var conds []*sqlf.Query
+	if a.Table != "" {
+		conds = append(conds, basestore.Eq("audit_log.table_name", a.Table))
+	}
+	if a.RecordID != "" {
+		conds = append(conds, basestore.Eq("audit_log.record_id", a.RecordID))
+	}
+	if a.Operation != "" {
+		conds = append(conds, basestore.Eq("audit_log.operation", a.Operation))
+	}
+	if a.Actor != "" {
+		conds = append(conds, basestore.Eq("audit_log.actor", a.Actor))
+	}
+	if a.ClaimedActor != "" {
+		conds = append(conds, basestore.Eq("audit_log.claimed_actor", a.ClaimedActor))
+	}
+	if a.RequestID != "" {
+		conds = append(conds, basestore.Eq("audit_log.request_id", a.RequestID))
+	}
+	if !a.Since.IsZero() {
+		conds = append(conds, basestore.Gte("audit_log.created_at", a.Since))
+	}
+	if !a.Until.IsZero() {
+		conds = append(conds, basestore.Lt("audit_log.created_at", a.Until))
+	}
+	return conds
+}
+
+func AuditWith(other basestore.ShareableStore) AuditStore {
+	return &auditStore{Store: basestore.NewWithHandle(other.Handle())}
+}
+
+type auditStore struct {
+	*basestore.Store
+}
+
+var _ AuditStore = &auditStore{}
+
+func (a *auditStore) Record(ctx context.Context, table, recordID, operation string, oldRow, newRow any) error {
+	if table == "" || recordID == "" || operation == "" {
+		return errors.New("table, record id and operation are required")
+	}
+
+	oldData, err := auditJSON(oldRow)
+	if err != nil {
+		return err
+	}
+
+	newData, err := auditJSON(newRow)
+	if err != nil {
+		return err
+	}
+
+	q := basestore.Insert("audit_log").
+		Columns(auditInsertColumns...).
+		Values(
+			table,
+			recordID,
+			operation,
+			sqlf.Sprintf("%s::jsonb", oldData),
+			sqlf.Sprintf("%s::jsonb", newData),
+			actorFromContext(ctx),
+			claimedActorFromContext(ctx),
+			requestIDFromContext(ctx),
+		).
+		Query()
+
+	return a.Exec(ctx, q)
+}
+
+// auditRow converts a possibly nil row pointer into a value for Record, which would
+// otherwise store a typed nil pointer as a JSON null rather than SQL NULL.
+func auditRow[T any](row *T) any {
+	if row == nil {
+		return nil
+	}
+	return row
+}
+
+// auditJSON marshals a row for the audit log, mapping a nil row to SQL NULL.
+func auditJSON(row any) (any, error) {
+	if row == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (a *auditStore) List(ctx context.Context, opts ListAuditArgs) ([]*types.AuditEntry, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultAuditLimit
+	}
+	if opts.Limit > maxAuditLimit {
+		opts.Limit = maxAuditLimit
+	}
+
+	query := basestore.Select(auditColumns...).
+		From("audit_log").
+		Where(opts.conds()...).
+		OrderBy(basestore.Desc("audit_log.id")).
+		Limit(opts.Limit).
+		Offset(opts.Offset).
+		Query()
+
+	rows, err := a.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*types.AuditEntry{}
+
+	scanAuditFunc := func(rows pgx.Rows) error {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	for rows.Next() {
+		if err := scanAuditFunc(rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, rows.Err()
+}
+
+func scanAuditEntry(sc dbutil.Scanner) (*types.AuditEntry, error) {
+	var entry types.AuditEntry
+	if err := sc.Scan(
+		&entry.ID,
+		&entry.Table,
+		&entry.RecordID,
+		&entry.Operation,
+		&entry.OldData,
+		&entry.NewData,
+		&entry.Actor,
+		&entry.ClaimedActor,
+		&entry.RequestID,
+		&entry.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,429 @@
+package basestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/keegancsmith/sqlf"
+)
+
+// The builders in this file assemble *sqlf.Query values without hand-written format
+// strings. Identifiers (tables and columns) are always passed as plain strings and
+// quoted, while values are always passed as arguments and end up as bind variables,
+// so the two can't be mixed up:
+//
+//	q := basestore.Select(userColumns...).
+//		From("users").
+//		Where(basestore.Eq("email", email)).
+//		OrderBy(basestore.Asc("id")).
+//		Limit(10).
+//		Query()
+//
+// Anything that is already a *sqlf.Query (such as the column lists kept by each
+// store) is embedded as-is.
+//
+// Query panics if the statement is incomplete, e.g. a SELECT without columns,
+// as that is a programming error when its parts are fixed. Build returns an
+// error wrapping ErrIncompleteStatement instead, for statements whose parts
+// come from input.
+
+// Ident returns a quoted SQL identifier made of the given parts, e.g.
+// Ident("users", "id") renders as "users"."id".
+func Ident(parts ...string) *sqlf.Query {
+	// sqlf treats the format string as a template, so escape any % that made
+	// it into the identifier.
+	return sqlf.Sprintf(strings.ReplaceAll(pgx.Identifier(parts).Sanitize(), "%", "%%"))
+}
+
+// Col returns a quoted column reference, splitting qualified names such as
+// "users.id" on the dot.
+func Col(name string) *sqlf.Query {
+	return Ident(strings.Split(name, ".")...)
+}
+
+// Cols returns quoted column references for each name.
+func Cols(names ...string) []*sqlf.Query {
+	cols := make([]*sqlf.Query, 0, len(names))
+	for _, name := range names {
+		cols = append(cols, Col(name))
+	}
+	return cols
+}
+
+// Eq returns the condition `col = value`.
+func Eq(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s = %s", Col(col), value) }
+
+// NotEq returns the condition `col <> value`.
+func NotEq(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s <> %s", Col(col), value) }
+
+// Lt returns the condition `col < value`.
+func Lt(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s < %s", Col(col), value) }
+
+// Lte returns the condition `col <= value`.
+func Lte(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s <= %s", Col(col), value) }
+
+// Gt returns the condition `col > value`.
+func Gt(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s > %s", Col(col), value) }
+
+// Gte returns the condition `col >= value`.
+func Gte(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s >= %s", Col(col), value) }
+
+// Like returns the condition `col LIKE pattern`.
+func Like(col string, pattern string) *sqlf.Query {
+	return sqlf.Sprintf("%s LIKE %s", Col(col), pattern)
+}
+
+// ILike returns the condition `col ILIKE pattern`.
+func ILike(col string, pattern string) *sqlf.Query {
+	return sqlf.Sprintf("%s ILIKE %s", Col(col), pattern)
+}
+
+// EscapeLike escapes the wildcards in s, so that it matches only itself when
+// used in a LIKE or ILIKE pattern.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// IsNull returns the condition `col IS NULL`.
+func IsNull(col string) *sqlf.Query { return sqlf.Sprintf("%s IS NULL", Col(col)) }
+
+// IsNotNull returns the condition `col IS NOT NULL`.
+func IsNotNull(col string) *sqlf.Query { return sqlf.Sprintf("%s IS NOT NULL", Col(col)) }
+
+// Any returns the condition `col = ANY(values)`. The values are sent as a single
+// array bind variable, so values should be a slice pgx knows how to encode.
+func Any(col string, values any) *sqlf.Query {
+	return sqlf.Sprintf("%s = ANY(%s)", Col(col), values)
+}
+
+// And joins the conditions with AND. It returns TRUE when there are none.
+func And(conds ...*sqlf.Query) *sqlf.Query {
+	if len(conds) == 0 {
+		return sqlf.Sprintf("TRUE")
+	}
+	return sqlf.Sprintf("(%s)", sqlf.Join(conds, " AND "))
+}
+
+// Or joins the conditions with OR. It returns FALSE when there are none.
+func Or(conds ...*sqlf.Query) *sqlf.Query {
+	if len(conds) == 0 {
+		return sqlf.Sprintf("FALSE")
+	}
+	return sqlf.Sprintf("(%s)", sqlf.Join(conds, " OR "))
+}
+
+// Not negates the condition.
+func Not(cond *sqlf.Query) *sqlf.Query { return sqlf.Sprintf("NOT (%s)", cond) }
+
+// Asc returns an ascending ORDER BY term.
+func Asc(col string) *sqlf.Query { return sqlf.Sprintf("%s ASC", Col(col)) }
+
+// Desc returns a descending ORDER BY term.
+func Desc(col string) *sqlf.Query { return sqlf.Sprintf("%s DESC", Col(col)) }
+
+// Assign returns the assignment `col = value` for use in SET clauses.
+func Assign(col string, value any) *sqlf.Query { return sqlf.Sprintf("%s = %s", Col(col), value) }
+
+// Excluded references the row proposed for insertion inside ON CONFLICT DO UPDATE.
+func Excluded(col string) *sqlf.Query { return sqlf.Sprintf("EXCLUDED.%s", Col(col)) }
+
+// SelectBuilder builds a SELECT statement.
+type SelectBuilder struct {
+	columns []*sqlf.Query
+	from    *sqlf.Query
+	where   []*sqlf.Query
+	orderBy []*sqlf.Query
+	limit   *int
+	offset  *int
+	lock    *sqlf.Query
+}
+
+// Select starts a SELECT statement for the given columns.
+func Select(cols ...*sqlf.Query) *SelectBuilder {
+	return &SelectBuilder{columns: cols}
+}
+
+// From sets the table to select from.
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.from = Ident(table)
+	return b
+}
+
+// Where adds conditions which are ANDed with any added before.
+func (b *SelectBuilder) Where(conds ...*sqlf.Query) *SelectBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// OrderBy appends ORDER BY terms, see Asc and Desc.
+func (b *SelectBuilder) OrderBy(terms ...*sqlf.Query) *SelectBuilder {
+	b.orderBy = append(b.orderBy, terms...)
+	return b
+}
+
+// Limit sets the LIMIT of the statement.
+func (b *SelectBuilder) Limit(n int) *SelectBuilder {
+	b.limit = &n
+	return b
+}
+
+// Offset sets the OFFSET of the statement.
+func (b *SelectBuilder) Offset(n int) *SelectBuilder {
+	b.offset = &n
+	return b
+}
+
+// ForUpdate locks the selected rows until the end of the transaction.
+func (b *SelectBuilder) ForUpdate() *SelectBuilder {
+	b.lock = sqlf.Sprintf("FOR UPDATE")
+	return b
+}
+
+// SkipLocked makes a locking select skip rows that are already locked instead of
+// waiting for them. It implies ForUpdate if no lock was set.
+func (b *SelectBuilder) SkipLocked() *SelectBuilder {
+	if b.lock == nil {
+		b.ForUpdate()
+	}
+	b.lock = sqlf.Sprintf("%s SKIP LOCKED", b.lock)
+	return b
+}
+
+// Query renders the statement, panicking if it is incomplete.
+func (b *SelectBuilder) Query() *sqlf.Query {
+	return mustBuild(b.Build())
+}
+
+// Build renders the statement, failing if it has no columns.
+func (b *SelectBuilder) Build() (*sqlf.Query, error) {
+	if len(b.columns) == 0 {
+		return nil, fmt.Errorf("%w: SELECT has no columns", ErrIncompleteStatement)
+	}
+
+	parts := []*sqlf.Query{sqlf.Sprintf("SELECT %s", sqlf.Join(b.columns, ", "))}
+	if b.from != nil {
+		parts = append(parts, sqlf.Sprintf("FROM %s", b.from))
+	}
+	if len(b.where) > 0 {
+		parts = append(parts, sqlf.Sprintf("WHERE %s", sqlf.Join(b.where, " AND ")))
+	}
+	if len(b.orderBy) > 0 {
+		parts = append(parts, sqlf.Sprintf("ORDER BY %s", sqlf.Join(b.orderBy, ", ")))
+	}
+	if b.limit != nil {
+		parts = append(parts, sqlf.Sprintf("LIMIT %s", *b.limit))
+	}
+	if b.offset != nil {
+		parts = append(parts, sqlf.Sprintf("OFFSET %s", *b.offset))
+	}
+	if b.lock != nil {
+		parts = append(parts, b.lock)
+	}
+	return sqlf.Join(parts, "\n"), nil
+}
+
+// InsertBuilder builds an INSERT statement.
+type InsertBuilder struct {
+	table          *sqlf.Query
+	columns        []*sqlf.Query
+	rows           [][]any
+	conflictTarget []*sqlf.Query
+	conflictAction *sqlf.Query
+	returning      []*sqlf.Query
+}
+
+// Insert starts an INSERT statement into the given table.
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{table: Ident(table)}
+}
+
+// Columns sets the columns being inserted.
+func (b *InsertBuilder) Columns(cols ...*sqlf.Query) *InsertBuilder {
+	b.columns = cols
+	return b
+}
+
+// Values appends a row of values, in the same order as Columns.
+func (b *InsertBuilder) Values(values ...any) *InsertBuilder {
+	b.rows = append(b.rows, values)
+	return b
+}
+
+// OnConflict sets the conflict target, which must be followed by DoNothing or
+// DoUpdateSet. An empty target matches any constraint (DO NOTHING only).
+func (b *InsertBuilder) OnConflict(target ...*sqlf.Query) *InsertBuilder {
+	b.conflictTarget = target
+	return b
+}
+
+// DoNothing makes conflicting rows be skipped.
+func (b *InsertBuilder) DoNothing() *InsertBuilder {
+	b.conflictAction = sqlf.Sprintf("DO NOTHING")
+	return b
+}
+
+// DoUpdateSet makes conflicting rows be updated with the given assignments, see
+// Assign and Excluded.
+func (b *InsertBuilder) DoUpdateSet(assignments ...*sqlf.Query) *InsertBuilder {
+	b.conflictAction = sqlf.Sprintf("DO UPDATE SET %s", sqlf.Join(assignments, ", "))
+	return b
+}
+
+// Returning sets the RETURNING columns.
+func (b *InsertBuilder) Returning(cols ...*sqlf.Query) *InsertBuilder {
+	b.returning = cols
+	return b
+}
+
+// Query renders the statement, panicking if it is incomplete.
+func (b *InsertBuilder) Query() *sqlf.Query {
+	return mustBuild(b.Build())
+}
+
+// Build renders the statement, failing if it has no columns or no rows, or a
+// row doesn't have a value for every column.
+func (b *InsertBuilder) Build() (*sqlf.Query, error) {
+	switch {
+	case len(b.columns) == 0:
+		return nil, fmt.Errorf("%w: INSERT has no columns", ErrIncompleteStatement)
+	case len(b.rows) == 0:
+		return nil, fmt.Errorf("%w: INSERT has no rows", ErrIncompleteStatement)
+	}
+
+	rows := make([]*sqlf.Query, 0, len(b.rows))
+	for i, row := range b.rows {
+		if len(row) != len(b.columns) {
+			return nil, fmt.Errorf("%w: INSERT row %d has %d values for %d columns", ErrIncompleteStatement, i, len(row), len(b.columns))
+		}
+		values := make([]*sqlf.Query, 0, len(row))
+		for _, v := range row {
+			values = append(values, sqlf.Sprintf("%s", v))
+		}
+		rows = append(rows, sqlf.Sprintf("(%s)", sqlf.Join(values, ", ")))
+	}
+
+	parts := []*sqlf.Query{
+		sqlf.Sprintf("INSERT INTO %s (%s)", b.table, sqlf.Join(b.columns, ", ")),
+		sqlf.Sprintf("VALUES %s", sqlf.Join(rows, ", ")),
+	}
+	if b.conflictAction != nil {
+		if len(b.conflictTarget) > 0 {
+			parts = append(parts, sqlf.Sprintf("ON CONFLICT (%s) %s", sqlf.Join(b.conflictTarget, ", "), b.conflictAction))
+		} else {
+			parts = append(parts, sqlf.Sprintf("ON CONFLICT %s", b.conflictAction))
+		}
+	}
+	if len(b.returning) > 0 {
+		parts = append(parts, sqlf.Sprintf("RETURNING %s", sqlf.Join(b.returning, ", ")))
+	}
+	return sqlf.Join(parts, "\n"), nil
+}
+
+// UpdateBuilder builds an UPDATE statement.
+type UpdateBuilder struct {
+	table     *sqlf.Query
+	set       []*sqlf.Query
+	where     []*sqlf.Query
+	returning []*sqlf.Query
+}
+
+// Update starts an UPDATE statement on the given table. Without a Where clause
+// every row is updated, so callers are expected to add one.
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: Ident(table)}
+}
+
+// Set adds the assignment `col = value`.
+func (b *UpdateBuilder) Set(col string, value any) *UpdateBuilder {
+	b.set = append(b.set, Assign(col, value))
+	return b
+}
+
+// SetExpr adds an assignment to a SQL expression, e.g. `version = version + 1`.
+func (b *UpdateBuilder) SetExpr(col string, expr *sqlf.Query) *UpdateBuilder {
+	b.set = append(b.set, sqlf.Sprintf("%s = %s", Col(col), expr))
+	return b
+}
+
+// Where adds conditions which are ANDed with any added before.
+func (b *UpdateBuilder) Where(conds ...*sqlf.Query) *UpdateBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Returning sets the RETURNING columns.
+func (b *UpdateBuilder) Returning(cols ...*sqlf.Query) *UpdateBuilder {
+	b.returning = cols
+	return b
+}
+
+// Query renders the statement, panicking if it is incomplete.
+func (b *UpdateBuilder) Query() *sqlf.Query {
+	return mustBuild(b.Build())
+}
+
+// Build renders the statement, failing if it sets nothing.
+func (b *UpdateBuilder) Build() (*sqlf.Query, error) {
+	if len(b.set) == 0 {
+		return nil, fmt.Errorf("%w: UPDATE sets no columns", ErrIncompleteStatement)
+	}
+
+	parts := []*sqlf.Query{
+		sqlf.Sprintf("UPDATE %s", b.table),
+		sqlf.Sprintf("SET %s", sqlf.Join(b.set, ", ")),
+	}
+	if len(b.where) > 0 {
+		parts = append(parts, sqlf.Sprintf("WHERE %s", sqlf.Join(b.where, " AND ")))
+	}
+	if len(b.returning) > 0 {
+		parts = append(parts, sqlf.Sprintf("RETURNING %s", sqlf.Join(b.returning, ", ")))
+	}
+	return sqlf.Join(parts, "\n"), nil
+}
+
+// DeleteBuilder builds a DELETE statement.
+type DeleteBuilder struct {
+	table     *sqlf.Query
+	where     []*sqlf.Query
+	returning []*sqlf.Query
+}
+
+// Delete starts a DELETE statement on the given table. Without a Where clause
+// every row is deleted, so callers are expected to add one.
+func Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: Ident(table)}
+}
+
+// Where adds conditions which are ANDed with any added before.
+func (b *DeleteBuilder) Where(conds ...*sqlf.Query) *DeleteBuilder {
+	b.where = append(b.where, conds...)
+	return b
+}
+
+// Returning sets the RETURNING columns.
+func (b *DeleteBuilder) Returning(cols ...*sqlf.Query) *DeleteBuilder {
+	b.returning = cols
+	return b
+}
+
+// Query renders the statement.
+func (b *DeleteBuilder) Query() *sqlf.Query {
+	parts := []*sqlf.Query{sqlf.Sprintf("DELETE FROM %s", b.table)}
+	if len(b.where) > 0 {
+		parts = append(parts, sqlf.Sprintf("WHERE %s", sqlf.Join(b.where, " AND ")))
+	}
+	if len(b.returning) > 0 {
+		parts = append(parts, sqlf.Sprintf("RETURNING %s", sqlf.Join(b.returning, ", ")))
+	}
+	return sqlf.Join(parts, "\n")
+}
+
+func mustBuild(q *sqlf.Query, err error) *sqlf.Query {
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
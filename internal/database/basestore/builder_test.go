@@ -0,0 +1,171 @@
+package basestore
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keegancsmith/sqlf"
+)
+
+func TestBuilders(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     *sqlf.Query
+		wantQuery string
+		wantArgs  []any
+	}{
+		{name: "Ident", query: Ident("users", "id"), wantQuery: `"users"."id"`},
+		{name: "Ident quoting", query: Ident(`we"ird%`), wantQuery: `"we""ird%"`},
+		{name: "Col", query: Col("users.id"), wantQuery: `"users"."id"`},
+		{name: "Cols", query: sqlf.Join(Cols("id", "users.email"), ", "), wantQuery: `"id", "users"."email"`},
+
+		{name: "Eq", query: Eq("id", 1), wantQuery: `"id" = $1`, wantArgs: []any{1}},
+		{name: "NotEq", query: NotEq("id", 1), wantQuery: `"id" <> $1`, wantArgs: []any{1}},
+		{name: "Lt", query: Lt("n", 1), wantQuery: `"n" < $1`, wantArgs: []any{1}},
+		{name: "Lte", query: Lte("n", 1), wantQuery: `"n" <= $1`, wantArgs: []any{1}},
+		{name: "Gt", query: Gt("n", 1), wantQuery: `"n" > $1`, wantArgs: []any{1}},
+		{name: "Gte", query: Gte("n", 1), wantQuery: `"n" >= $1`, wantArgs: []any{1}},
+		{name: "Like", query: Like("name", "a%"), wantQuery: `"name" LIKE $1`, wantArgs: []any{"a%"}},
+		{name: "ILike", query: ILike("name", "a%"), wantQuery: `"name" ILIKE $1`, wantArgs: []any{"a%"}},
+		{
+			name:      "Like with EscapeLike",
+			query:     Like("name", EscapeLike(`50%_off\`)+"%"),
+			wantQuery: `"name" LIKE $1`,
+			wantArgs:  []any{`50\%\_off\\%`},
+		},
+		{name: "IsNull", query: IsNull("deleted_at"), wantQuery: `"deleted_at" IS NULL`},
+		{name: "IsNotNull", query: IsNotNull("deleted_at"), wantQuery: `"deleted_at" IS NOT NULL`},
+		{name: "Any", query: Any("id", []int{1, 2}), wantQuery: `"id" = ANY($1)`, wantArgs: []any{[]int{1, 2}}},
+
+		{name: "empty And", query: And(), wantQuery: `TRUE`},
+		{name: "empty Or", query: Or(), wantQuery: `FALSE`},
+		{name: "And", query: And(Eq("a", 1)), wantQuery: `("a" = $1)`, wantArgs: []any{1}},
+		{
+			name:      "Or of Ands",
+			query:     Or(And(Eq("a", 1), Eq("b", 2)), Eq("c", 3)),
+			wantQuery: `(("a" = $1 AND "b" = $2) OR "c" = $3)`,
+			wantArgs:  []any{1, 2, 3},
+		},
+		{name: "Not", query: Not(Eq("a", 1)), wantQuery: `NOT ("a" = $1)`, wantArgs: []any{1}},
+		{name: "Asc", query: Asc("id"), wantQuery: `"id" ASC`},
+		{name: "Desc", query: Desc("id"), wantQuery: `"id" DESC`},
+		{name: "Assign", query: Assign("a", 1), wantQuery: `"a" = $1`, wantArgs: []any{1}},
+		{name: "Excluded", query: Excluded("a"), wantQuery: `EXCLUDED."a"`},
+
+		{name: "Select", query: Select(Col("id")).Query(), wantQuery: `SELECT "id"`},
+		{
+			name: "Select with every clause",
+			query: Select(Cols("id", "name")...).
+				From("users").
+				Where(Eq("a", 1), Gt("b", 2)).
+				OrderBy(Asc("name"), Desc("id")).
+				Limit(10).
+				Offset(20).
+				SkipLocked().
+				Query(),
+			wantQuery: `SELECT "id", "name" FROM "users" WHERE "a" = $1 AND "b" > $2 ORDER BY "name" ASC, "id" DESC LIMIT $3 OFFSET $4 FOR UPDATE SKIP LOCKED`,
+			wantArgs:  []any{1, 2, 10, 20},
+		},
+		{
+			name:      "Select for update",
+			query:     Select(Col("id")).From("users").ForUpdate().Query(),
+			wantQuery: `SELECT "id" FROM "users" FOR UPDATE`,
+		},
+
+		{
+			name:      "Insert",
+			query:     Insert("users").Columns(Cols("a", "b")...).Values(1, sqlf.Sprintf("now()")).Query(),
+			wantQuery: `INSERT INTO "users" ("a", "b") VALUES ($1, now())`,
+			wantArgs:  []any{1},
+		},
+		{
+			name:      "Insert rows",
+			query:     Insert("users").Columns(Col("a")).Values(1).Values(2).Returning(Col("id")).Query(),
+			wantQuery: `INSERT INTO "users" ("a") VALUES ($1), ($2) RETURNING "id"`,
+			wantArgs:  []any{1, 2},
+		},
+		{
+			name:      "Insert on conflict do nothing",
+			query:     Insert("users").Columns(Col("a")).Values(1).OnConflict().DoNothing().Query(),
+			wantQuery: `INSERT INTO "users" ("a") VALUES ($1) ON CONFLICT DO NOTHING`,
+			wantArgs:  []any{1},
+		},
+		{
+			name: "Insert on conflict do update",
+			query: Insert("users").Columns(Cols("id", "a")...).Values(1, 2).
+				OnConflict(Col("id")).DoUpdateSet(Assign("a", Excluded("a"))).
+				Query(),
+			wantQuery: `INSERT INTO "users" ("id", "a") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "a" = EXCLUDED."a"`,
+			wantArgs:  []any{1, 2},
+		},
+
+		{
+			name: "Update",
+			query: Update("users").
+				Set("a", 1).
+				SetExpr("version", sqlf.Sprintf("version + %s", 1)).
+				Where(Eq("id", 2)).
+				Returning(Col("id")).
+				Query(),
+			wantQuery: `UPDATE "users" SET "a" = $1, "version" = version + $2 WHERE "id" = $3 RETURNING "id"`,
+			wantArgs:  []any{1, 1, 2},
+		},
+		{name: "Update every row", query: Update("users").Set("a", 1).Query(), wantQuery: `UPDATE "users" SET "a" = $1`, wantArgs: []any{1}},
+
+		{
+			name:      "Delete",
+			query:     Delete("users").Where(Eq("id", 1)).Returning(Col("id")).Query(),
+			wantQuery: `DELETE FROM "users" WHERE "id" = $1 RETURNING "id"`,
+			wantArgs:  []any{1},
+		},
+		{name: "Delete every row", query: Delete("users").Query(), wantQuery: `DELETE FROM "users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeSpace(tt.query.Query(sqlf.PostgresBindVar)); got != tt.wantQuery {
+				t.Errorf("got query %s, want %s", got, tt.wantQuery)
+			}
+			if got := tt.query.Args(); len(got) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(got, tt.wantArgs) {
+					t.Errorf("got args %#v, want %#v", got, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
+
+// normalizeSpace collapses the line breaks and padding sqlf.Join puts around
+// separators, so that tests can spell out queries on one line.
+func normalizeSpace(q string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(q), " "), " ,", ",")
+}
+
+func TestBuildIncomplete(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() (*sqlf.Query, error)
+	}{
+		{name: "Select without columns", build: Select().From("users").Build},
+		{name: "Insert without columns", build: Insert("users").Values(1).Build},
+		{name: "Insert without rows", build: Insert("users").Columns(Col("a")).Build},
+		{name: "Insert with a short row", build: Insert("users").Columns(Cols("a", "b")...).Values(1).Build},
+		{name: "Update without assignments", build: Update("users").Where(Eq("id", 1)).Build},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.build()
+			if !errors.Is(err, ErrIncompleteStatement) {
+				t.Fatalf("got query %v and error %v, want ErrIncompleteStatement", q, err)
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Query on an incomplete statement didn't panic")
+		}
+	}()
+	Select().Query()
+}
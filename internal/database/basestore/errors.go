@@ -9,3 +9,7 @@ var ErrNotTransactable = errors.New("store: not transactable")
 // ErrNotInTransaction occurs when an operation can only be run in a transaction
 // but the invariant wasn't in place.
 var ErrNotInTransaction = errors.New("store: not in a transaction")
+
+// ErrIncompleteStatement occurs when a statement builder is missing a part the
+// statement can't be rendered without, such as the columns of a SELECT.
+var ErrIncompleteStatement = errors.New("store: incomplete statement")
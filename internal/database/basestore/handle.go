@@ -2,10 +2,10 @@ package basestore
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"strings"
 	"sync"
 
@@ -59,30 +59,25 @@ var (
 )
 
 // NewHandleWithDB returns a new transactable database handle using the given database connection.
-func NewHandleWithDB(logger *log.Logger, pool *pgxpool.Pool, txOptions pgx.TxOptions) TransactableHandle {
-	logger.SetPrefix("db-handle")
+func NewHandleWithDB(logger *slog.Logger, pool *pgxpool.Pool, txOptions pgx.TxOptions) TransactableHandle {
 	return &dbHandle{
 		Pool:      pool,
 		logger:    logger,
 		txOptions: txOptions,
+		openTxs:   &txCounter{},
 	}
 }
 
 // NewHandleWithTx returns a new transactable database handle using the given transaction.
-func NewHandleWithTx(tx pgx.Tx, txOptions pgx.TxOptions) TransactableHandle {
-	return &txHandle{
-		lockingTx: &lockingTx{
-			tx:     tx,
-			logger: log.New(os.Stdout, "tx-handle", log.LstdFlags),
-		},
-		txOptions: txOptions,
-	}
+func NewHandleWithTx(logger *slog.Logger, tx pgx.Tx, txOptions pgx.TxOptions) TransactableHandle {
+	return &txHandle{lockingTx: newLockingTx(logger, tx), txOptions: txOptions}
 }
 
 type dbHandle struct {
 	*pgxpool.Pool
 	txOptions pgx.TxOptions
-	logger    *log.Logger
+	logger    *slog.Logger
+	openTxs   *txCounter
 }
 
 func (h *dbHandle) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
@@ -98,7 +93,12 @@ func (h *dbHandle) Transact(ctx context.Context) (TransactableHandle, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &txHandle{lockingTx: &lockingTx{tx: tx, logger: h.logger}, txOptions: h.txOptions}, nil
+
+	h.openTxs.add(1)
+	ltx := newLockingTx(h.logger, tx)
+	ltx.onDone = func() { h.openTxs.add(-1) }
+	ltx.logger.DebugContext(ctx, "transaction started")
+	return &txHandle{lockingTx: ltx, txOptions: h.txOptions}, nil
 }
 
 func (h *dbHandle) Done(_ context.Context, err error) error {
@@ -115,24 +115,45 @@ func (h *txHandle) InTransaction() bool {
 }
 
 func (h *txHandle) Transact(ctx context.Context) (TransactableHandle, error) {
-	savepointID, err := newTxSavepoint(ctx, h.lockingTx)
-	if err != nil {
-		return nil, err
-	}
-
-	return &savepointHandle{lockingTx: h.lockingTx, savepointID: savepointID}, nil
+	return newSavepointHandle(ctx, h.lockingTx, h.logger)
 }
 
 func (h *txHandle) Done(ctx context.Context, err error) error {
+	defer h.finish()
+
 	if err == nil {
-		return h.Commit(ctx)
+		if err := h.Commit(ctx); err != nil {
+			h.logger.DebugContext(ctx, "transaction failed to commit", "error", err)
+			return err
+		}
+		h.logger.DebugContext(ctx, "transaction committed")
+		h.runCommitHooks()
+		return nil
 	}
+
+	h.logger.DebugContext(ctx, "transaction rolled back", "error", err)
 	return errors.Join(err, h.Rollback(ctx))
 }
 
 type savepointHandle struct {
 	*lockingTx
 	savepointID string
+	// hooksMark is the number of commit hooks registered when the savepoint was
+	// created; hooks registered after it are dropped if the savepoint rolls back.
+	hooksMark int
+	// logger shadows the transaction's logger to add the savepoint ID.
+	logger *slog.Logger
+}
+
+func newSavepointHandle(ctx context.Context, tx *lockingTx, logger *slog.Logger) (*savepointHandle, error) {
+	savepointID, err := newTxSavepoint(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.With(slog.String("savepoint_id", savepointID))
+	logger.DebugContext(ctx, "savepoint created")
+	return &savepointHandle{lockingTx: tx, savepointID: savepointID, hooksMark: tx.numCommitHooks(), logger: logger}, nil
 }
 
 func (h *savepointHandle) InTransaction() bool {
@@ -140,24 +161,90 @@ func (h *savepointHandle) InTransaction() bool {
 }
 
 func (h *savepointHandle) Transact(ctx context.Context) (TransactableHandle, error) {
-	savepointID, err := newTxSavepoint(ctx, h.lockingTx)
-	if err != nil {
-		return nil, err
-	}
-
-	return &savepointHandle{lockingTx: h.lockingTx, savepointID: savepointID}, nil
+	return newSavepointHandle(ctx, h.lockingTx, h.lockingTx.logger)
 }
 
 func (h *savepointHandle) Done(ctx context.Context, err error) error {
 	if err == nil {
 		_, execErr := h.Exec(ctx, fmt.Sprintf(commitSavepointQuery, h.savepointID))
+		h.logger.DebugContext(ctx, "savepoint released")
 		return execErr
 	}
 
+	h.logger.DebugContext(ctx, "savepoint rolled back", "error", err)
 	_, execErr := h.Exec(context.Background(), fmt.Sprintf(rollbackSavepointQuery, h.savepointID))
+	h.truncateCommitHooks(h.hooksMark)
 	return errors.Join(err, execErr)
 }
 
+// WaitForTransactions blocks until every transaction begun from handle has
+// committed or rolled back, or until ctx is done. Transactions begun after it
+// is called are waited for too. It returns immediately for handles to
+// transactions.
+func WaitForTransactions(ctx context.Context, handle TransactableHandle) error {
+	if h, ok := handle.(*dbHandle); ok {
+		return h.openTxs.wait(ctx)
+	}
+	return nil
+}
+
+// txCounter counts the transactions open on a pool.
+type txCounter struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed when n drops to zero, if anyone is waiting for it to.
+	idle chan struct{}
+}
+
+func (c *txCounter) add(delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.n += delta
+	if c.n == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
+func (c *txCounter) wait(ctx context.Context) error {
+	c.mu.Lock()
+	if c.n == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	idle := c.idle
+	c.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		n := c.n
+		c.mu.Unlock()
+		return fmt.Errorf("%d transactions still open: %w", n, ctx.Err())
+	}
+}
+
+// AfterCommit registers f to run once the transaction the handle belongs to has
+// committed. If the handle is not in a transaction, f is run immediately. Hooks
+// registered within a savepoint that is rolled back, or within a transaction that
+// is rolled back, never run.
+func AfterCommit(handle TransactableHandle, f func()) {
+	switch h := handle.(type) {
+	case *txHandle:
+		h.addCommitHook(f)
+	case *savepointHandle:
+		h.addCommitHook(f)
+	default:
+		f()
+	}
+}
+
 const (
 	savepointQuery         = "SAVEPOINT %s"
 	commitSavepointQuery   = "RELEASE %s"
@@ -201,16 +288,75 @@ var ErrConcurrentTransactionAccess = errors.New("transaction used concurrently")
 // successfully prevented an issue. In the future, this will likely be upgraded
 // to a hard error. Think of this like the race detector, not a race protector.
 type lockingTx struct {
-	tx     pgx.Tx
-	mu     sync.Mutex
-	logger *log.Logger
+	tx pgx.Tx
+	mu sync.Mutex
+	// logger carries the ID of the transaction, so that everything logged
+	// about it can be correlated.
+	logger *slog.Logger
+
+	hooksMu     sync.Mutex
+	commitHooks []func()
+
+	// onDone, if set, is called once the transaction has committed or rolled
+	// back.
+	onDone   func()
+	doneOnce sync.Once
+}
+
+func newLockingTx(logger *slog.Logger, tx pgx.Tx) *lockingTx {
+	return &lockingTx{tx: tx, logger: logger.With(slog.String("tx_id", newTxID()))}
+}
+
+func newTxID() string {
+	id := uuid.New()
+	return hex.EncodeToString(id[:4])
+}
+
+func (t *lockingTx) finish() {
+	if t.onDone != nil {
+		t.doneOnce.Do(t.onDone)
+	}
+}
+
+func (t *lockingTx) addCommitHook(f func()) {
+	t.hooksMu.Lock()
+	defer t.hooksMu.Unlock()
+
+	t.commitHooks = append(t.commitHooks, f)
+}
+
+func (t *lockingTx) numCommitHooks() int {
+	t.hooksMu.Lock()
+	defer t.hooksMu.Unlock()
+
+	return len(t.commitHooks)
+}
+
+func (t *lockingTx) truncateCommitHooks(n int) {
+	t.hooksMu.Lock()
+	defer t.hooksMu.Unlock()
+
+	if n < len(t.commitHooks) {
+		t.commitHooks = t.commitHooks[:n]
+	}
+}
+
+func (t *lockingTx) runCommitHooks() {
+	t.hooksMu.Lock()
+	hooks := t.commitHooks
+	t.commitHooks = nil
+	t.hooksMu.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
 }
 
-func (t *lockingTx) lock() {
+func (t *lockingTx) lock(ctx context.Context) {
 	if !t.mu.TryLock() {
 		// For now, log an error, but try to serialize access anyways to try to
 		// keep things slightly safer.
-		t.logger.Printf("error: transaction used concurrently. %v", ErrConcurrentTransactionAccess)
+		t.logger.ErrorContext(ctx, "transaction used concurrently", "error", ErrConcurrentTransactionAccess)
 		t.mu.Lock()
 	}
 }
@@ -220,35 +366,35 @@ func (t *lockingTx) unlock() {
 }
 
 func (t *lockingTx) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
-	t.lock()
+	t.lock(ctx)
 	defer t.unlock()
 
 	return t.tx.Exec(ctx, query, args...)
 }
 
 func (t *lockingTx) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
-	t.lock()
+	t.lock(ctx)
 	defer t.unlock()
 
 	return t.tx.Query(ctx, query, args...)
 }
 
 func (t *lockingTx) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
-	t.lock()
+	t.lock(ctx)
 	defer t.unlock()
 
 	return t.tx.QueryRow(ctx, query, args...)
 }
 
 func (t *lockingTx) Commit(ctx context.Context) error {
-	t.lock()
+	t.lock(ctx)
 	defer t.unlock()
 
 	return t.tx.Commit(ctx)
 }
 
 func (t *lockingTx) Rollback(ctx context.Context) error {
-	t.lock()
+	t.lock(ctx)
 	defer t.unlock()
 
 	return t.tx.Rollback(ctx)
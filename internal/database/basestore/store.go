@@ -93,6 +93,12 @@ func (s *Store) InTransaction() bool {
 	return s.handle.InTransaction()
 }
 
+// AfterCommit registers f to run once the store's transaction commits, or runs it
+// immediately if the store is not in a transaction. See the package-level AfterCommit.
+func (s *Store) AfterCommit(f func()) {
+	AfterCommit(s.handle, f)
+}
+
 // Transact returns a new store whose methods operate within the context of a new transaction
 // or a new savepoint. This method will return an error if the underlying connection cannot be
 // interface upgraded to a TxBeginner.
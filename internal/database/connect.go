@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/retry"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// baseConnectBackoff and maxConnectBackoff bound the delay between attempts
+	// to reach the database.
+	baseConnectBackoff = 250 * time.Millisecond
+	maxConnectBackoff  = 10 * time.Second
+
+	// healthCheckInterval is how often the database is pinged to tell whether
+	// it is reachable.
+	healthCheckInterval = 5 * time.Second
+)
+
+// waitForDatabase pings the database until it answers, backing off between
+// attempts, for up to cfg.StartupTimeout. Errors that retrying won't fix, such
+// as a wrong password, are returned straight away, though a rejected password
+// is fetched again and retried once in case it was just rotated.
+func waitForDatabase(ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool, cfg config.Database, creds *credentialCache) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.StartupTimeout)
+	defer cancel()
+
+	refetched := false
+	for attempt := 1; ; attempt++ {
+		err := pool.Ping(ctx)
+		if err == nil {
+			if attempt > 1 {
+				logger.InfoContext(ctx, "database reachable", "database", cfg.String(), "attempts", attempt)
+			}
+			return nil
+		}
+		if !isTransientConnectError(err) {
+			if refetched || !creds.checkAuthError(err) {
+				return err
+			}
+			refetched = true
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("database not reachable after %d attempts in %s: %w", attempt, cfg.StartupTimeout, err)
+		}
+
+		delay := retry.Backoff(baseConnectBackoff, maxConnectBackoff, attempt-1)
+		logger.WarnContext(ctx, "database not reachable yet, retrying", "database", cfg.String(), "error", err, "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %d attempts in %s: %w", attempt, cfg.StartupTimeout, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransientConnectError reports whether err may go away by connecting again,
+// which is the case unless the server rejected our credentials or database.
+func isTransientConnectError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+	switch pgErr.Code {
+	case "28000", // invalid_authorization_specification
+		"28P01", // invalid_password
+		"3D000": // invalid_catalog_name
+		return false
+	}
+	return true
+}
+
+// Health is whether the database is reachable, as last seen by the pool's
+// health monitor.
+type Health struct {
+	Degraded bool `json:"degraded"`
+	// Error is why the database is unreachable, when Degraded is set.
+	Error string `json:"error,omitempty"`
+	// Since is when the database became reachable or unreachable.
+	Since time.Time `json:"since"`
+}
+
+// healthMonitor pings the database periodically so that an outage shows up
+// as a degraded state, rather than only as errors from whatever happens to
+// query the database next.
+type healthMonitor struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+	creds  *credentialCache
+
+	mu     sync.Mutex
+	health Health
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func startHealthMonitor(pool *pgxpool.Pool, logger *slog.Logger, creds *credentialCache) *healthMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &healthMonitor{
+		pool:   pool,
+		logger: logger,
+		creds:  creds,
+		health: Health{Since: time.Now()},
+		stop:   cancel,
+		done:   make(chan struct{}),
+	}
+	go m.run(ctx)
+	return m
+}
+
+func (m *healthMonitor) run(ctx context.Context) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckInterval)
+		err := m.pool.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		// Nothing else sees why the pool fails to connect, so this is where
+		// we notice that the credentials have been rotated under us.
+		m.creds.checkAuthError(err)
+		m.record(err)
+	}
+}
+
+// record updates the health with the outcome of a ping, logging when the
+// database goes down or comes back.
+func (m *healthMonitor) record(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case err != nil && !m.health.Degraded:
+		m.health = Health{Degraded: true, Error: err.Error(), Since: time.Now()}
+		m.logger.Error("database unreachable", "error", err)
+	case err != nil:
+		m.health.Error = err.Error()
+	case m.health.Degraded:
+		m.logger.Info("database reachable again", "downtime", time.Since(m.health.Since))
+		m.health = Health{Since: time.Now()}
+	}
+}
+
+func (m *healthMonitor) get() Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.health
+}
+
+func (m *healthMonitor) close() {
+	m.stop()
+	<-m.done
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/jackc/pgpassfile"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Credentials are what connections log in to the database with.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// CredentialProvider supplies the credentials for new connections. It is
+// consulted whenever the cached credentials expire or are rejected by the
+// server, so that they can be rotated without a restart.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials always provides the same credentials.
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Credentials(context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// FileCredentials reads the password from a file, such as a mounted secret,
+// rereading it whenever the file changes.
+type FileCredentials struct {
+	User string
+	Path string
+
+	mu       sync.Mutex
+	version  fileVersion
+	password string
+}
+
+func (c *FileCredentials) Credentials(context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	version, err := statVersion(c.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if !version.equal(c.version) {
+		data, err := os.ReadFile(c.Path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		c.password = strings.TrimRight(string(data), "\r\n")
+		c.version = version
+	}
+	return Credentials{User: c.User, Password: c.password}, nil
+}
+
+// ExecCredentials runs a shell command that prints the password, e.g. one that
+// generates a short-lived authentication token.
+type ExecCredentials struct {
+	User    string
+	Command string
+}
+
+func (c ExecCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.Command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("running password command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	password := strings.TrimRight(string(out), "\r\n")
+	if password == "" {
+		return Credentials{}, errors.New("password command printed nothing")
+	}
+	return Credentials{User: c.User, Password: password}, nil
+}
+
+// PgpassCredentials looks the password up in a pgpass file, rereading it
+// whenever the file changes.
+type PgpassCredentials struct {
+	Path     string
+	Host     string
+	Port     int
+	Database string
+	User     string
+
+	mu       sync.Mutex
+	version  fileVersion
+	password string
+}
+
+func (c *PgpassCredentials) Credentials(context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	version, err := statVersion(c.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if !version.equal(c.version) {
+		passfile, err := pgpassfile.ReadPassfile(c.Path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		host := c.Host
+		if strings.HasPrefix(host, "/") {
+			// Like libpq, look up Unix socket connections as localhost.
+			host = "localhost"
+		}
+		c.password = passfile.FindPassword(host, strconv.Itoa(c.Port), c.Database, c.User)
+		c.version = version
+	}
+	return Credentials{User: c.User, Password: c.password}, nil
+}
+
+// credentialProvider returns the provider for where cfg says the password
+// comes from.
+func credentialProvider(cfg config.Database) CredentialProvider {
+	switch {
+	case cfg.PasswordCommand != "":
+		return ExecCredentials{User: cfg.User, Command: cfg.PasswordCommand}
+	case cfg.PasswordFile != "":
+		return &FileCredentials{User: cfg.User, Path: cfg.PasswordFile}
+	case cfg.Password != "":
+		return StaticCredentials{User: cfg.User, Password: cfg.Password.Value()}
+	}
+
+	path := cfg.Passfile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return StaticCredentials{User: cfg.User}
+		}
+		path = filepath.Join(home, ".pgpass")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return StaticCredentials{User: cfg.User}
+	}
+	return &PgpassCredentials{Path: path, Host: cfg.Host, Port: cfg.Port, Database: cfg.Name, User: cfg.User}
+}
+
+// credentialCache caches the credentials a provider returns for ttl, so that
+// slow providers such as commands aren't run for every new connection.
+type credentialCache struct {
+	provider CredentialProvider
+	ttl      time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	current Credentials
+	ok      bool
+	expires time.Time
+	// rotated is when the credentials last changed.
+	rotated time.Time
+}
+
+func newCredentialCache(logger *slog.Logger, cfg config.Database) *credentialCache {
+	return &credentialCache{
+		provider: credentialProvider(cfg),
+		ttl:      cfg.CredentialsTTL,
+		logger:   logger,
+	}
+}
+
+// get returns the cached credentials, fetching them from the provider if they
+// have expired. If that fails, the credentials fetched before are returned,
+// as they may well still work.
+func (c *credentialCache) get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ok && time.Now().Before(c.expires) {
+		return c.current, nil
+	}
+
+	creds, err := c.provider.Credentials(ctx)
+	if err != nil {
+		if !c.ok {
+			return Credentials{}, fmt.Errorf("getting database credentials: %w", err)
+		}
+		c.logger.WarnContext(ctx, "error refreshing database credentials, using the previous ones", "error", err)
+		return c.current, nil
+	}
+
+	if c.ok && creds != c.current {
+		c.logger.InfoContext(ctx, "database credentials rotated", "user", creds.User)
+		c.rotated = time.Now()
+	}
+	c.current, c.ok, c.expires = creds, true, time.Now().Add(c.ttl)
+	return creds, nil
+}
+
+// checkAuthError expires the cached credentials if err shows that the server
+// rejected them, so that the next connection fetches them again, and reports
+// whether it did.
+func (c *credentialCache) checkAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "28P01" { // invalid_password
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expires = time.Time{}
+	return true
+}
+
+// stale reports whether conn was opened with credentials that have since been
+// rotated and should be replaced now. Rather than replacing every such
+// connection at once, each is replaced at a point spread over the given
+// duration after the rotation, picked by its backend PID.
+func (c *credentialCache) stale(conn *pgx.Conn, spread time.Duration) bool {
+	cc := conn.Config()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.ok || (cc.User == c.current.User && cc.Password == c.current.Password) {
+		return false
+	}
+	if spread <= 0 {
+		return true
+	}
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, conn.PgConn().PID())
+	offset := time.Duration(h.Sum64() % uint64(spread))
+	return time.Since(c.rotated) >= offset
+}
+
+// apply sets the current credentials on cc.
+func (c *credentialCache) apply(ctx context.Context, cc *pgx.ConnConfig) error {
+	creds, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	cc.User = creds.User
+	cc.Password = creds.Password
+	return nil
+}
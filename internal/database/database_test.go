@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbtest"
+)
+
+// newTestDB connects to the migrated test database, skipping t if there is
+// none.
+func newTestDB(t *testing.T) DB {
+	t.Helper()
+
+	db, err := New(context.Background(), testLogger, dbtest.Config(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+	dbtest.Migrate(t, sdb)
+	return db
+}
@@ -3,9 +3,12 @@ package database
 import (
 	"context"
 	"database/sql"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/logging"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/retry"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -14,8 +17,11 @@ import (
 
 type db struct {
 	*basestore.Store
-	pool   *pgxpool.Pool
-	logger *log.Logger
+	pool      *pgxpool.Pool
+	logger    *slog.Logger
+	creds     *credentialCache
+	health    *healthMonitor
+	userCache *UserCache
 }
 
 func (d *db) acquire(ctx context.Context) (*pgxpool.Conn, func(), error) {
@@ -46,19 +52,86 @@ func (d *db) GetSQLDB() *sql.DB {
 }
 
 func (d *db) Close() {
+	d.health.close()
 	d.pool.Close()
 }
 
+func (d *db) WaitForTransactions(ctx context.Context) error {
+	return basestore.WaitForTransactions(ctx, d.Handle())
+}
+
 func (d *db) WithTransact(ctx context.Context, f func(tx DB) error) error {
 	return d.Store.WithTransact(ctx, func(tx *basestore.Store) error {
-		return f(&db{logger: d.logger, Store: tx})
+		return f(&db{pool: d.pool, logger: d.logger, creds: d.creds, health: d.health, userCache: d.userCache, Store: tx})
 	})
 }
 
 func (d *db) Users() UserStore {
-	return UsersWith(d.Store)
+	return CachedUsers(UsersWith(d.Store), d.userCache)
+}
+
+func (d *db) Audit() AuditStore {
+	return AuditWith(d.Store)
+}
+
+func (d *db) Outbox() OutboxStore {
+	return OutboxWith(d.Store)
+}
+
+func (d *db) Jobs() JobStore {
+	return JobsWith(d.Store)
+}
+
+// ListenUserCacheInvalidations listens until ctx is canceled, reconnecting
+// with backoff whenever the connection is lost.
+func (d *db) ListenUserCacheInvalidations(ctx context.Context) error {
+	failures := 0
+	for {
+		start := time.Now()
+		err := ListenUserCacheInvalidations(ctx, d.pool, d.userCache, d.logger)
+		if ctx.Err() != nil {
+			return nil
+		}
+		d.creds.checkAuthError(err)
+
+		// Start backing off from scratch if we had been listening for a while.
+		if time.Since(start) > maxConnectBackoff {
+			failures = 0
+		}
+		delay := retry.Backoff(baseConnectBackoff, maxConnectBackoff, failures)
+		failures++
+		d.logger.WarnContext(ctx, "lost user cache invalidation listener, reconnecting", "error", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (d *db) ListeningForUserCacheInvalidations() bool {
+	return d.userCache.listening.Load()
+}
+
+func (d *db) Ping(ctx context.Context) error {
+	return d.pool.Ping(ctx)
+}
+
+func (d *db) Stat() *pgxpool.Stat {
+	return d.pool.Stat()
+}
+
+func (d *db) Health() Health {
+	return d.health.get()
 }
 
 func (d *db) People() PeopleStore {
 	return PeopleWith(d.Store)
 }
+
+// withMethod records the store method being run in ctx, so that everything it
+// logs, including its transactions, says where it came from.
+func withMethod(ctx context.Context, store, method string) context.Context {
+	return logging.WithAttrs(ctx, slog.String("store", store), slog.String("method", method))
+}
@@ -0,0 +1,64 @@
+// Package dbtest points tests at the Postgres database named by the
+// PGX_STORE_TEST_DSN environment variable, e.g.
+// "host=localhost user=postgres dbname=pgx_store_test". Tests using it are
+// skipped when the variable isn't set.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+	"github.com/jackc/pgx/v5"
+)
+
+// DSNEnv is the environment variable holding the test database's connection
+// string.
+const DSNEnv = "PGX_STORE_TEST_DSN"
+
+// Logger discards everything, for the code under test to log to.
+var Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// DSN returns the test database's connection string, skipping t if it isn't
+// set.
+func DSN(t testing.TB) string {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skip(DSNEnv + " not set")
+	}
+	return dsn
+}
+
+// Config returns the settings for connecting to the test database, skipping
+// t if there is none.
+func Config(t testing.TB) config.Database {
+	t.Helper()
+
+	cc, err := pgx.ParseConfig(DSN(t))
+	if err != nil {
+		t.Fatalf("invalid %s: %v", DSNEnv, err)
+	}
+	cfg := config.Default().Database
+	cfg.Host, cfg.Port, cfg.Name = cc.Host, int(cc.Port), cc.Database
+	cfg.User, cfg.Password = cc.User, config.Secret(cc.Password)
+	if cc.TLSConfig == nil {
+		cfg.SSLMode = "disable"
+	}
+	return cfg
+}
+
+// Migrate applies every migration to db.
+func Migrate(t testing.TB, db *sql.DB) {
+	t.Helper()
+
+	if _, err := migration.NewRunner(db, Logger).Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+}
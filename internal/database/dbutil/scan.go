@@ -1,5 +1,40 @@
 package dbutil
 
+import (
+	"errors"
+	"reflect"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
 type Scanner interface {
 	Scan(dest ...any) error
 }
+
+// IsUniqueViolation reports whether err is Postgres rejecting a write because it
+// would violate a unique constraint or index.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+var errStopScan = errors.New("stop scan")
+
+type recordingScanner struct {
+	types []reflect.Type
+}
+
+func (s *recordingScanner) Scan(dest ...any) error {
+	for _, d := range dest {
+		s.types = append(s.types, reflect.TypeOf(d).Elem())
+	}
+	return errStopScan
+}
+
+// ScanTypes returns the types of the values a scan function reads each column
+// into, in column order, by running it against a fake row.
+func ScanTypes(scan func(Scanner) error) []reflect.Type {
+	sc := &recordingScanner{}
+	_ = scan(sc)
+	return sc.types
+}
@@ -0,0 +1,7 @@
+package database
+
+// Stores for new tables don't need to be written by hand. Once the table's
+// migration exists, add a directive for it below and run `go generate
+// ./internal/database`; see cmd/storegen for the available flags.
+//
+//	//go:generate go run ../../cmd/storegen -table widgets
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/keegancsmith/sqlf"
+)
+
+const (
+	JobStateQueued    = "queued"
+	JobStateRunning   = "running"
+	JobStateCompleted = "completed"
+	// JobStateFailed marks jobs that used up all their attempts.
+	JobStateFailed = "failed"
+)
+
+// ErrJobLockLost is returned when a worker tries to update a job it no longer
+// holds, because its visibility timeout passed and another worker took it over.
+var ErrJobLockLost = errors.New("job is no longer locked by this worker")
+
+var jobColumns = []*sqlf.Query{
+	sqlf.Sprintf("jobs.id"),
+	sqlf.Sprintf("jobs.kind"),
+	sqlf.Sprintf("jobs.payload"),
+	sqlf.Sprintf("jobs.state"),
+	sqlf.Sprintf("jobs.unique_key"),
+	sqlf.Sprintf("jobs.attempts"),
+	sqlf.Sprintf("jobs.max_attempts"),
+	sqlf.Sprintf("jobs.last_error"),
+	sqlf.Sprintf("jobs.run_at"),
+	sqlf.Sprintf("jobs.locked_by"),
+	sqlf.Sprintf("jobs.locked_until"),
+	sqlf.Sprintf("jobs.heartbeat_at"),
+	sqlf.Sprintf("jobs.created_at"),
+	sqlf.Sprintf("jobs.finished_at"),
+}
+
+var jobInsertColumns = []*sqlf.Query{
+	sqlf.Sprintf("kind"),
+	sqlf.Sprintf("payload"),
+	sqlf.Sprintf("unique_key"),
+	sqlf.Sprintf("max_attempts"),
+	sqlf.Sprintf("run_at"),
+}
+
+type EnqueueJobOptions struct {
+	// RunAt schedules the job for later. It runs as soon as possible if unset.
+	RunAt time.Time
+	// UniqueKey, if set, makes Enqueue a no-op while another job of the same kind
+	// with the same key is queued or running; that job is returned instead.
+	UniqueKey string
+	// MaxAttempts defaults to 25.
+	MaxAttempts int32
+}
+
+type JobStore interface {
+	// Enqueue adds a job. Like every other write it uses the store's handle, so a
+	// job enqueued inside a transaction only becomes visible once it commits.
+	Enqueue(ctx context.Context, kind string, payload any, opts EnqueueJobOptions) (*types.Job, error)
+
+	// Dequeue locks up to limit runnable jobs of the given kinds for workerID for
+	// the duration of the visibility timeout. Runnable jobs are queued jobs that
+	// are due, and running jobs whose lock expired without a heartbeat. A job
+	// whose lock expired on its last attempt, e.g. because its worker crashed,
+	// never reaches Fail, so it is moved to the failed state instead.
+	Dequeue(ctx context.Context, workerID string, kinds []string, limit int, visibilityTimeout time.Duration) ([]*types.Job, error)
+	// Heartbeat extends the worker's lock on a running job.
+	Heartbeat(ctx context.Context, id int64, workerID string, visibilityTimeout time.Duration) error
+	Complete(ctx context.Context, id int64, workerID string) error
+	// Fail records a failed attempt. The job is queued again at retryAt unless it
+	// has no attempts left, in which case it moves to the failed state.
+	Fail(ctx context.Context, id int64, workerID string, jobErr error, retryAt time.Time) error
+}
+
+func JobsWith(other basestore.ShareableStore) JobStore {
+	return &jobStore{Store: basestore.NewWithHandle(other.Handle())}
+}
+
+type jobStore struct {
+	*basestore.Store
+}
+
+var _ JobStore = &jobStore{}
+
+const defaultJobMaxAttempts = 25
+
+func (j *jobStore) Enqueue(ctx context.Context, kind string, payload any, opts EnqueueJobOptions) (*types.Job, error) {
+	if kind == "" {
+		return nil, errors.New("no job kind provided")
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultJobMaxAttempts
+	}
+
+	var uniqueKey, runAt any
+	if opts.UniqueKey != "" {
+		uniqueKey = opts.UniqueKey
+	}
+	runAt = sqlf.Sprintf("now()")
+	if !opts.RunAt.IsZero() {
+		runAt = opts.RunAt
+	}
+
+	q := basestore.Insert("jobs").
+		Columns(jobInsertColumns...).
+		Values(kind, sqlf.Sprintf("%s::jsonb", string(b)), uniqueKey, opts.MaxAttempts, runAt).
+		// The only constraint an insert can conflict on is the unique key index.
+		OnConflict().DoNothing().
+		Returning(jobColumns...).
+		Query()
+
+	existing := basestore.Select(jobColumns...).
+		From("jobs").
+		Where(
+			basestore.Eq("jobs.kind", kind),
+			basestore.Eq("jobs.unique_key", opts.UniqueKey),
+			basestore.Any("jobs.state", []string{JobStateQueued, JobStateRunning}),
+		).
+		Query()
+
+	for attempt := 1; ; attempt++ {
+		job, err := scanJob(j.QueryRow(ctx, q))
+		if err != pgx.ErrNoRows || opts.UniqueKey == "" {
+			return job, err
+		}
+
+		// The job we conflicted with may have finished since, freeing the key,
+		// in which case we try inserting again.
+		job, err = scanJob(j.QueryRow(ctx, existing))
+		if err != pgx.ErrNoRows {
+			return job, err
+		}
+		if attempt == maxEnqueueAttempts {
+			return nil, fmt.Errorf("enqueuing %s job with unique key %q: the key was taken and released %d times", kind, opts.UniqueKey, attempt)
+		}
+	}
+}
+
+// maxEnqueueAttempts bounds how often Enqueue retries when the job holding a
+// unique key keeps finishing between its insert and its lookup.
+const maxEnqueueAttempts = 3
+
+func (j *jobStore) Dequeue(ctx context.Context, workerID string, kinds []string, limit int, visibilityTimeout time.Duration) ([]*types.Job, error) {
+	if workerID == "" {
+		return nil, errors.New("no worker id provided")
+	}
+	if len(kinds) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	expired := basestore.And(basestore.Eq("state", JobStateRunning), basestore.Lt("locked_until", sqlf.Sprintf("now()")))
+
+	exhausted := basestore.Update("jobs").
+		Set("state", JobStateFailed).
+		Set("last_error", "lock expired on the last attempt").
+		Set("locked_by", nil).
+		Set("locked_until", nil).
+		SetExpr("finished_at", sqlf.Sprintf("now()")).
+		Where(basestore.Any("kind", kinds), expired, sqlf.Sprintf("attempts >= max_attempts")).
+		Query()
+	if err := j.Exec(ctx, exhausted); err != nil {
+		return nil, err
+	}
+
+	runnable := basestore.Select(sqlf.Sprintf("id")).
+		From("jobs").
+		Where(
+			basestore.Any("kind", kinds),
+			basestore.Or(
+				basestore.And(basestore.Eq("state", JobStateQueued), basestore.Lte("run_at", sqlf.Sprintf("now()"))),
+				basestore.And(expired, sqlf.Sprintf("attempts < max_attempts")),
+			),
+		).
+		OrderBy(basestore.Asc("run_at"), basestore.Asc("id")).
+		Limit(limit).
+		SkipLocked().
+		Query()
+
+	q := basestore.Update("jobs").
+		Set("state", JobStateRunning).
+		Set("locked_by", workerID).
+		SetExpr("locked_until", lockedUntil(visibilityTimeout)).
+		SetExpr("heartbeat_at", sqlf.Sprintf("now()")).
+		SetExpr("attempts", sqlf.Sprintf("attempts + 1")).
+		Where(sqlf.Sprintf("id IN (%s)", runnable)).
+		Returning(jobColumns...).
+		Query()
+
+	rows, err := j.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*types.Job{}
+
+	scanJobFunc := func(rows pgx.Rows) error {
+		job, err := scanJob(rows)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	}
+
+	for rows.Next() {
+		if err := scanJobFunc(rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, rows.Err()
+}
+
+func (j *jobStore) Heartbeat(ctx context.Context, id int64, workerID string, visibilityTimeout time.Duration) error {
+	return j.execLocked(ctx, basestore.Update("jobs").
+		SetExpr("locked_until", lockedUntil(visibilityTimeout)).
+		SetExpr("heartbeat_at", sqlf.Sprintf("now()")).
+		Where(lockedBy(id, workerID)...).
+		Query())
+}
+
+func (j *jobStore) Complete(ctx context.Context, id int64, workerID string) error {
+	return j.execLocked(ctx, basestore.Update("jobs").
+		Set("state", JobStateCompleted).
+		Set("locked_by", nil).
+		Set("locked_until", nil).
+		SetExpr("finished_at", sqlf.Sprintf("now()")).
+		Where(lockedBy(id, workerID)...).
+		Query())
+}
+
+func (j *jobStore) Fail(ctx context.Context, id int64, workerID string, jobErr error, retryAt time.Time) error {
+	return j.execLocked(ctx, basestore.Update("jobs").
+		SetExpr("state", sqlf.Sprintf("CASE WHEN attempts >= max_attempts THEN %s ELSE %s END", JobStateFailed, JobStateQueued)).
+		SetExpr("finished_at", sqlf.Sprintf("CASE WHEN attempts >= max_attempts THEN now() END")).
+		Set("run_at", retryAt).
+		Set("last_error", jobErr.Error()).
+		Set("locked_by", nil).
+		Set("locked_until", nil).
+		Where(lockedBy(id, workerID)...).
+		Query())
+}
+
+// execLocked runs an update of a job held by a worker, returning ErrJobLockLost
+// if the worker no longer holds it.
+func (j *jobStore) execLocked(ctx context.Context, q *sqlf.Query) error {
+	res, err := j.ExecResult(ctx, q)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrJobLockLost
+	}
+	return nil
+}
+
+func lockedBy(id int64, workerID string) []*sqlf.Query {
+	return []*sqlf.Query{
+		basestore.Eq("id", id),
+		basestore.Eq("state", JobStateRunning),
+		basestore.Eq("locked_by", workerID),
+	}
+}
+
+func lockedUntil(visibilityTimeout time.Duration) *sqlf.Query {
+	return sqlf.Sprintf("now() + %s::interval", fmt.Sprintf("%d milliseconds", visibilityTimeout.Milliseconds()))
+}
+
+func scanJob(sc dbutil.Scanner) (*types.Job, error) {
+	var job types.Job
+	if err := sc.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.State,
+		&job.UniqueKey,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.LockedBy,
+		&job.LockedUntil,
+		&job.HeartbeatAt,
+		&job.CreatedAt,
+		&job.FinishedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
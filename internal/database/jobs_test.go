@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+)
+
+// scriptedTx is a transaction whose single-row queries return the given jobs
+// in turn, with nil meaning no row.
+type scriptedTx struct {
+	pgx.Tx
+	rows []*types.Job
+}
+
+func (t *scriptedTx) QueryRow(context.Context, string, ...any) pgx.Row {
+	if len(t.rows) == 0 {
+		return jobRow{}
+	}
+	job := t.rows[0]
+	t.rows = t.rows[1:]
+	return jobRow{job: job}
+}
+
+type jobRow struct {
+	job *types.Job
+}
+
+func (r jobRow) Scan(dest ...any) error {
+	if r.job == nil {
+		return pgx.ErrNoRows
+	}
+	*dest[0].(*int64) = r.job.ID
+	*dest[1].(*string) = r.job.Kind
+	return nil
+}
+
+func TestEnqueueUniqueKeyFallback(t *testing.T) {
+	existing := &types.Job{ID: 1, Kind: "test"}
+	inserted := &types.Job{ID: 2, Kind: "test"}
+
+	tests := []struct {
+		name    string
+		rows    []*types.Job
+		want    *types.Job
+		wantErr bool
+	}{
+		{name: "inserted", rows: []*types.Job{inserted}, want: inserted},
+		{name: "conflict returns the existing job", rows: []*types.Job{nil, existing}, want: existing},
+		{name: "existing job finished before the lookup", rows: []*types.Job{nil, nil, inserted}, want: inserted},
+		{name: "key keeps being released", rows: []*types.Job{nil, nil, nil, nil, nil, nil}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle := basestore.NewHandleWithTx(testLogger, &scriptedTx{rows: tt.rows}, pgx.TxOptions{})
+			store := JobsWith(basestore.NewWithHandle(handle))
+
+			job, err := store.Enqueue(context.Background(), "test", nil, EnqueueJobOptions{UniqueKey: "k"})
+			if tt.wantErr {
+				if err == nil || errors.Is(err, pgx.ErrNoRows) {
+					t.Fatalf("got %v, want an error other than pgx.ErrNoRows", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.ID != tt.want.ID {
+				t.Errorf("got job %d, want %d", job.ID, tt.want.ID)
+			}
+		})
+	}
+}
+
+// TestJobQueue runs against the test database (see dbtest). Every subtest
+// uses a job kind of its own, so jobs left behind by other tests are ignored.
+func TestJobQueue(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	store := db.Jobs()
+
+	newKind := func(t *testing.T) []string {
+		return []string{fmt.Sprintf("test-%s-%d", strings.ReplaceAll(t.Name(), "/", "-"), time.Now().UnixNano())}
+	}
+	enqueue := func(t *testing.T, kind string, opts EnqueueJobOptions) *types.Job {
+		t.Helper()
+		job, err := store.Enqueue(ctx, kind, map[string]string{"k": "v"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	dequeue := func(t *testing.T, workerID string, kinds []string, limit int, timeout time.Duration) []*types.Job {
+		t.Helper()
+		jobs, err := store.Dequeue(ctx, workerID, kinds, limit, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs
+	}
+	get := func(t *testing.T, id int64) *types.Job {
+		t.Helper()
+		s := JobsWith(db).(*jobStore)
+		job, err := scanJob(s.QueryRow(ctx, basestore.Select(jobColumns...).From("jobs").Where(basestore.Eq("id", id)).Query()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	t.Run("claiming", func(t *testing.T) {
+		kinds := newKind(t)
+		first := enqueue(t, kinds[0], EnqueueJobOptions{})
+		second := enqueue(t, kinds[0], EnqueueJobOptions{})
+		enqueue(t, kinds[0], EnqueueJobOptions{RunAt: time.Now().Add(time.Hour)})
+
+		jobs := dequeue(t, "w1", kinds, 1, time.Minute)
+		if len(jobs) != 1 || jobs[0].ID != first.ID {
+			t.Fatalf("got %v, want job %d first", jobs, first.ID)
+		}
+		if job := jobs[0]; job.State != JobStateRunning || job.Attempts != 1 || job.LockedBy == nil || *job.LockedBy != "w1" {
+			t.Errorf("claimed job is %+v, want it running for w1 on its first attempt", job)
+		}
+
+		jobs = dequeue(t, "w2", kinds, 10, time.Minute)
+		if len(jobs) != 1 || jobs[0].ID != second.ID {
+			t.Fatalf("got %v, want only job %d, the other being locked or not due", jobs, second.ID)
+		}
+		if jobs := dequeue(t, "w3", kinds, 10, time.Minute); len(jobs) != 0 {
+			t.Errorf("got %v, want nothing left to claim", jobs)
+		}
+	})
+
+	t.Run("heartbeats", func(t *testing.T) {
+		kinds := newKind(t)
+		job := enqueue(t, kinds[0], EnqueueJobOptions{})
+		claimed := dequeue(t, "w1", kinds, 1, time.Minute)[0]
+
+		if err := store.Heartbeat(ctx, job.ID, "w2", time.Minute); !errors.Is(err, ErrJobLockLost) {
+			t.Errorf("heartbeat from another worker: got %v, want ErrJobLockLost", err)
+		}
+		if err := store.Heartbeat(ctx, job.ID, "w1", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if got := get(t, job.ID); !got.LockedUntil.After(*claimed.LockedUntil) {
+			t.Errorf("heartbeat did not extend the lock past %s, got %s", claimed.LockedUntil, got.LockedUntil)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		kinds := newKind(t)
+		job := enqueue(t, kinds[0], EnqueueJobOptions{MaxAttempts: 3})
+		dequeue(t, "w1", kinds, 1, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+
+		jobs := dequeue(t, "w2", kinds, 1, time.Minute)
+		if len(jobs) != 1 || jobs[0].ID != job.ID || jobs[0].Attempts != 2 {
+			t.Fatalf("got %v, want job %d reclaimed on its second attempt", jobs, job.ID)
+		}
+		if err := store.Complete(ctx, job.ID, "w1"); !errors.Is(err, ErrJobLockLost) {
+			t.Errorf("completing from the worker that lost the lock: got %v, want ErrJobLockLost", err)
+		}
+		if err := store.Complete(ctx, job.ID, "w2"); err != nil {
+			t.Fatal(err)
+		}
+		if got := get(t, job.ID); got.State != JobStateCompleted || got.FinishedAt == nil {
+			t.Errorf("got %+v, want a completed job", got)
+		}
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		kinds := newKind(t)
+		job := enqueue(t, kinds[0], EnqueueJobOptions{MaxAttempts: 2})
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			if jobs := dequeue(t, "w1", kinds, 1, time.Minute); len(jobs) != 1 {
+				t.Fatalf("attempt %d: got %d jobs, want 1", attempt, len(jobs))
+			}
+			if err := store.Fail(ctx, job.ID, "w1", errors.New("boom"), time.Now().Add(-time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := get(t, job.ID)
+		if got.State != JobStateFailed || got.FinishedAt == nil || got.LastError == nil || *got.LastError != "boom" {
+			t.Errorf("got %+v, want a failed job", got)
+		}
+		if jobs := dequeue(t, "w1", kinds, 1, time.Minute); len(jobs) != 0 {
+			t.Errorf("got %v, want the failed job left alone", jobs)
+		}
+	})
+
+	t.Run("expiry on the last attempt", func(t *testing.T) {
+		kinds := newKind(t)
+		job := enqueue(t, kinds[0], EnqueueJobOptions{MaxAttempts: 1})
+		dequeue(t, "w1", kinds, 1, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+
+		if jobs := dequeue(t, "w2", kinds, 1, time.Minute); len(jobs) != 0 {
+			t.Fatalf("got %v, want a job with no attempts left not to be reclaimed", jobs)
+		}
+		got := get(t, job.ID)
+		if got.State != JobStateFailed || got.FinishedAt == nil || got.LockedBy != nil || got.LastError == nil {
+			t.Errorf("got %+v, want the job failed", got)
+		}
+	})
+
+	t.Run("unique key", func(t *testing.T) {
+		kinds := newKind(t)
+		first := enqueue(t, kinds[0], EnqueueJobOptions{UniqueKey: "k"})
+		if again := enqueue(t, kinds[0], EnqueueJobOptions{UniqueKey: "k"}); again.ID != first.ID {
+			t.Errorf("got job %d, want the queued job %d", again.ID, first.ID)
+		}
+
+		dequeue(t, "w1", kinds, 1, time.Minute)
+		if again := enqueue(t, kinds[0], EnqueueJobOptions{UniqueKey: "k"}); again.ID != first.ID {
+			t.Errorf("got job %d, want the running job %d", again.ID, first.ID)
+		}
+
+		if err := store.Complete(ctx, first.ID, "w1"); err != nil {
+			t.Fatal(err)
+		}
+		if next := enqueue(t, kinds[0], EnqueueJobOptions{UniqueKey: "k"}); next.ID == first.ID {
+			t.Error("got the completed job, want a new one")
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/keegancsmith/sqlf"
+)
+
+const (
+	OutboxStatePending   = "pending"
+	OutboxStateDelivered = "delivered"
+	// OutboxStateDead marks messages that failed too many times. They are kept
+	// for inspection but never retried.
+	OutboxStateDead = "dead"
+)
+
+var outboxColumns = []*sqlf.Query{
+	sqlf.Sprintf("outbox.id"),
+	sqlf.Sprintf("outbox.topic"),
+	sqlf.Sprintf("outbox.payload"),
+	sqlf.Sprintf("outbox.state"),
+	sqlf.Sprintf("outbox.attempts"),
+	sqlf.Sprintf("outbox.last_error"),
+	sqlf.Sprintf("outbox.available_at"),
+	sqlf.Sprintf("outbox.created_at"),
+	sqlf.Sprintf("outbox.delivered_at"),
+}
+
+var outboxInsertColumns = []*sqlf.Query{
+	sqlf.Sprintf("topic"),
+	sqlf.Sprintf("payload"),
+}
+
+type OutboxStore interface {
+	// Enqueue records a message to be published. It is written with the store's
+	// handle, so when called inside a transaction the message is only published
+	// if the transaction commits.
+	Enqueue(ctx context.Context, topic string, payload any) (*types.OutboxMessage, error)
+
+	// Claim locks up to limit pending messages that are due, skipping any that
+	// another dispatcher has locked. It must be called inside a transaction, and
+	// the messages stay claimed until that transaction ends.
+	Claim(ctx context.Context, limit int) ([]*types.OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	// MarkFailed records a failed delivery and schedules the next attempt.
+	MarkFailed(ctx context.Context, id int64, deliveryErr error, retryAt time.Time) error
+	// MarkDead records a failed delivery and gives up on the message.
+	MarkDead(ctx context.Context, id int64, deliveryErr error) error
+}
+
+func OutboxWith(other basestore.ShareableStore) OutboxStore {
+	return &outboxStore{Store: basestore.NewWithHandle(other.Handle())}
+}
+
+type outboxStore struct {
+	*basestore.Store
+}
+
+var _ OutboxStore = &outboxStore{}
+
+func (o *outboxStore) Enqueue(ctx context.Context, topic string, payload any) (*types.OutboxMessage, error) {
+	if topic == "" {
+		return nil, errors.New("no topic provided")
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	q := basestore.Insert("outbox").
+		Columns(outboxInsertColumns...).
+		Values(topic, sqlf.Sprintf("%s::jsonb", string(b))).
+		Returning(outboxColumns...).
+		Query()
+
+	return scanOutboxMessage(o.QueryRow(ctx, q))
+}
+
+func (o *outboxStore) Claim(ctx context.Context, limit int) ([]*types.OutboxMessage, error) {
+	if !o.InTransaction() {
+		return nil, basestore.ErrNotInTransaction
+	}
+
+	query := basestore.Select(outboxColumns...).
+		From("outbox").
+		Where(
+			basestore.Eq("outbox.state", OutboxStatePending),
+			basestore.Lte("outbox.available_at", sqlf.Sprintf("now()")),
+		).
+		OrderBy(basestore.Asc("outbox.id")).
+		Limit(limit).
+		SkipLocked().
+		Query()
+
+	rows, err := o.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*types.OutboxMessage{}
+
+	scanOutboxFunc := func(rows pgx.Rows) error {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, msg)
+		return nil
+	}
+
+	for rows.Next() {
+		if err := scanOutboxFunc(rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return messages, rows.Err()
+}
+
+func (o *outboxStore) MarkDelivered(ctx context.Context, id int64) error {
+	return o.Exec(ctx, basestore.Update("outbox").
+		Set("state", OutboxStateDelivered).
+		SetExpr("delivered_at", sqlf.Sprintf("now()")).
+		SetExpr("attempts", sqlf.Sprintf("attempts + 1")).
+		Where(basestore.Eq("id", id)).
+		Query())
+}
+
+func (o *outboxStore) MarkFailed(ctx context.Context, id int64, deliveryErr error, retryAt time.Time) error {
+	return o.Exec(ctx, basestore.Update("outbox").
+		Set("last_error", deliveryErr.Error()).
+		Set("available_at", retryAt).
+		SetExpr("attempts", sqlf.Sprintf("attempts + 1")).
+		Where(basestore.Eq("id", id)).
+		Query())
+}
+
+func (o *outboxStore) MarkDead(ctx context.Context, id int64, deliveryErr error) error {
+	return o.Exec(ctx, basestore.Update("outbox").
+		Set("state", OutboxStateDead).
+		Set("last_error", deliveryErr.Error()).
+		SetExpr("attempts", sqlf.Sprintf("attempts + 1")).
+		Where(basestore.Eq("id", id)).
+		Query())
+}
+
+func scanOutboxMessage(sc dbutil.Scanner) (*types.OutboxMessage, error) {
+	var msg types.OutboxMessage
+	if err := sc.Scan(
+		&msg.ID,
+		&msg.Topic,
+		&msg.Payload,
+		&msg.State,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.AvailableAt,
+		&msg.CreatedAt,
+		&msg.DeliveredAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+func TestOutbox(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	newTopic := func(t *testing.T) string {
+		return fmt.Sprintf("test.%s.%d", t.Name(), time.Now().UnixNano())
+	}
+	enqueue := func(t *testing.T, store OutboxStore, topic string) *types.OutboxMessage {
+		t.Helper()
+		msg, err := store.Enqueue(ctx, topic, map[string]string{"k": "v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	// claim claims in its own transaction, which stays open while f runs, and
+	// returns which of the given messages were claimed. Other tests' messages
+	// may be pending too, so the rest are ignored.
+	claim := func(t *testing.T, store DB, want []*types.OutboxMessage, f func(tx DB)) []int64 {
+		t.Helper()
+		var claimed []int64
+		err := store.WithTransact(ctx, func(tx DB) error {
+			messages, err := tx.Outbox().Claim(ctx, 1000)
+			if err != nil {
+				return err
+			}
+			for _, msg := range messages {
+				for _, w := range want {
+					if msg.ID == w.ID {
+						claimed = append(claimed, msg.ID)
+					}
+				}
+			}
+			if f != nil {
+				f(tx)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+	get := func(t *testing.T, id int64) *types.OutboxMessage {
+		t.Helper()
+		s := OutboxWith(db).(*outboxStore)
+		msg, err := scanOutboxMessage(s.QueryRow(ctx, basestore.Select(outboxColumns...).From("outbox").Where(basestore.Eq("id", id)).Query()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	t.Run("claim outside a transaction", func(t *testing.T) {
+		if _, err := db.Outbox().Claim(ctx, 1); !errors.Is(err, basestore.ErrNotInTransaction) {
+			t.Errorf("got %v, want ErrNotInTransaction", err)
+		}
+	})
+
+	t.Run("claiming", func(t *testing.T) {
+		topic := newTopic(t)
+		first := enqueue(t, db.Outbox(), topic)
+		second := enqueue(t, db.Outbox(), topic)
+		want := []*types.OutboxMessage{first, second}
+
+		claimed := claim(t, db, want, func(DB) {
+			if again := claim(t, db, want, nil); len(again) != 0 {
+				t.Errorf("another dispatcher claimed %v, want the locked messages skipped", again)
+			}
+		})
+		if len(claimed) != 2 || claimed[0] != first.ID || claimed[1] != second.ID {
+			t.Errorf("got %v, want [%d %d]", claimed, first.ID, second.ID)
+		}
+		if again := claim(t, db, want, nil); len(again) != 2 {
+			t.Errorf("got %v, want both messages claimable again once released", again)
+		}
+	})
+
+	t.Run("delivered", func(t *testing.T) {
+		msg := enqueue(t, db.Outbox(), newTopic(t))
+		claim(t, db, []*types.OutboxMessage{msg}, func(tx DB) {
+			if err := tx.Outbox().MarkDelivered(ctx, msg.ID); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		got := get(t, msg.ID)
+		if got.State != OutboxStateDelivered || got.Attempts != 1 || got.DeliveredAt == nil {
+			t.Errorf("got %+v, want a message delivered on its first attempt", got)
+		}
+		if claimed := claim(t, db, []*types.OutboxMessage{msg}, nil); len(claimed) != 0 {
+			t.Errorf("got %v, want delivered messages left alone", claimed)
+		}
+	})
+
+	t.Run("retry backoff", func(t *testing.T) {
+		msg := enqueue(t, db.Outbox(), newTopic(t))
+		want := []*types.OutboxMessage{msg}
+		claim(t, db, want, func(tx DB) {
+			if err := tx.Outbox().MarkFailed(ctx, msg.ID, errors.New("boom"), time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		got := get(t, msg.ID)
+		if got.State != OutboxStatePending || got.Attempts != 1 || got.LastError == nil || *got.LastError != "boom" {
+			t.Errorf("got %+v, want a pending message with one failed attempt", got)
+		}
+		if claimed := claim(t, db, want, nil); len(claimed) != 0 {
+			t.Errorf("got %v, want the message held back until it is due", claimed)
+		}
+
+		claim(t, db, nil, func(tx DB) {
+			if err := tx.Outbox().MarkFailed(ctx, msg.ID, errors.New("boom"), time.Now().Add(-time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if claimed := claim(t, db, want, nil); len(claimed) != 1 {
+			t.Errorf("got %v, want the message claimable once due", claimed)
+		}
+		if got := get(t, msg.ID); got.Attempts != 2 {
+			t.Errorf("got %d attempts, want 2", got.Attempts)
+		}
+	})
+
+	t.Run("dead", func(t *testing.T) {
+		msg := enqueue(t, db.Outbox(), newTopic(t))
+		claim(t, db, []*types.OutboxMessage{msg}, func(tx DB) {
+			if err := tx.Outbox().MarkDead(ctx, msg.ID, errors.New("boom")); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		got := get(t, msg.ID)
+		if got.State != OutboxStateDead || got.Attempts != 1 || got.LastError == nil || *got.LastError != "boom" {
+			t.Errorf("got %+v, want a dead message", got)
+		}
+		if claimed := claim(t, db, []*types.OutboxMessage{msg}, nil); len(claimed) != 0 {
+			t.Errorf("got %v, want dead messages never retried", claimed)
+		}
+	})
+
+	t.Run("enqueue in a transaction", func(t *testing.T) {
+		topic := newTopic(t)
+		rollback := errors.New("rollback")
+
+		var rolledBack *types.OutboxMessage
+		err := db.WithTransact(ctx, func(tx DB) error {
+			rolledBack = enqueue(t, tx.Outbox(), topic)
+			if claimed := claim(t, db, []*types.OutboxMessage{rolledBack}, nil); len(claimed) != 0 {
+				t.Errorf("got %v, want an uncommitted message invisible to dispatchers", claimed)
+			}
+			return rollback
+		})
+		if !errors.Is(err, rollback) {
+			t.Fatalf("got %v, want the rollback error", err)
+		}
+
+		var committed *types.OutboxMessage
+		err = db.WithTransact(ctx, func(tx DB) error {
+			committed = enqueue(t, tx.Outbox(), topic)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claimed := claim(t, db, []*types.OutboxMessage{rolledBack, committed}, nil)
+		if len(claimed) != 1 || claimed[0] != committed.ID {
+			t.Errorf("got %v, want only the committed message %d", claimed, committed.ID)
+		}
+	})
+}
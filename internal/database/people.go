@@ -3,16 +3,20 @@ package database
 import (
 	"context"
 	"errors"
+	"strconv"
 
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
 	"github.com/keegancsmith/sqlf"
 )
 
 var peopleColumns = []*sqlf.Query{
 	sqlf.Sprintf("id"),
 	sqlf.Sprintf("user_id"),
+	sqlf.Sprintf("version"),
+	sqlf.Sprintf("deleted_at"),
 }
 
 var peopleInsertColumns = []*sqlf.Query{
@@ -21,6 +25,12 @@ var peopleInsertColumns = []*sqlf.Query{
 
 type PeopleStore interface {
 	Create(ctx context.Context, userID string) (*types.People, error)
+
+	// DeleteByUserID, RestoreByUserID and PurgeByUserID apply the matching
+	// UserStore operation to the user's person record, if there is one.
+	DeleteByUserID(ctx context.Context, userID string) error
+	RestoreByUserID(ctx context.Context, userID string) error
+	PurgeByUserID(ctx context.Context, userID string) error
 }
 
 type peopleStore struct {
@@ -33,25 +43,105 @@ func PeopleWith(other basestore.ShareableStore) PeopleStore {
 	return &peopleStore{Store: basestore.NewWithHandle(other.Handle())}
 }
 
-const peopleCreateQueryFmtStr = `
-INSERT INTO
-	people (%s)
-	VALUES (%s)
-	RETURNING %s
-`
-
 func (p *peopleStore) Create(ctx context.Context, userID string) (*types.People, error) {
+	ctx = withMethod(ctx, "people", "Create")
+
 	if userID == "" {
 		return nil, errors.New("no user id provided")
 	}
 
-	q := sqlf.Sprintf(
-		peopleCreateQueryFmtStr,
-		sqlf.Join(peopleInsertColumns, ", "),
-		userID,
-		sqlf.Join(peopleColumns, ", "),
-	)
-	return scanPeople(p.QueryRow(ctx, q))
+	q := basestore.Insert("people").
+		Columns(peopleInsertColumns...).
+		Values(userID).
+		Returning(peopleColumns...).
+		Query()
+
+	var person *types.People
+	err := p.WithTransact(ctx, func(tx *basestore.Store) (err error) {
+		person, err = scanPeople(tx.QueryRow(ctx, q))
+		if err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "people", strconv.FormatInt(person.ID, 10), AuditOperationCreate, nil, person)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return person, nil
+}
+
+func (p *peopleStore) DeleteByUserID(ctx context.Context, userID string) error {
+	ctx = withMethod(ctx, "people", "DeleteByUserID")
+
+	q := basestore.Update("people").
+		SetExpr("deleted_at", sqlf.Sprintf("now()")).
+		SetExpr("version", sqlf.Sprintf("version + 1")).
+		Where(basestore.Eq("user_id", userID), basestore.IsNull("deleted_at")).
+		Returning(peopleColumns...).
+		Query()
+
+	return p.mutateByUserID(ctx, userID, q, AuditOperationDelete)
+}
+
+func (p *peopleStore) RestoreByUserID(ctx context.Context, userID string) error {
+	ctx = withMethod(ctx, "people", "RestoreByUserID")
+
+	q := basestore.Update("people").
+		Set("deleted_at", nil).
+		SetExpr("version", sqlf.Sprintf("version + 1")).
+		Where(basestore.Eq("user_id", userID), basestore.IsNotNull("deleted_at")).
+		Returning(peopleColumns...).
+		Query()
+
+	return p.mutateByUserID(ctx, userID, q, AuditOperationRestore)
+}
+
+func (p *peopleStore) PurgeByUserID(ctx context.Context, userID string) error {
+	ctx = withMethod(ctx, "people", "PurgeByUserID")
+
+	q := basestore.Delete("people").
+		Where(basestore.Eq("user_id", userID)).
+		Query()
+
+	return p.mutateByUserID(ctx, userID, q, AuditOperationPurge)
+}
+
+// mutateByUserID runs q, which changes the person record of the given user, and
+// audits the change. Nothing is audited if the user has no matching record.
+func (p *peopleStore) mutateByUserID(ctx context.Context, userID string, q *sqlf.Query, operation string) error {
+	if userID == "" {
+		return errors.New("no user id provided")
+	}
+
+	return p.WithTransact(ctx, func(tx *basestore.Store) error {
+		old, err := scanPeople(tx.QueryRow(ctx, basestore.Select(peopleColumns...).
+			From("people").
+			Where(basestore.Eq("user_id", userID)).
+			Query()))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+
+		var updated *types.People
+		if operation == AuditOperationPurge {
+			err = tx.Exec(ctx, q)
+		} else {
+			updated, err = scanPeople(tx.QueryRow(ctx, q))
+			if err == pgx.ErrNoRows {
+				// Already in the requested state.
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "people", strconv.FormatInt(old.ID, 10), operation, old, auditRow(updated))
+	})
 }
 
 func scanPeople(sc dbutil.Scanner) (*types.People, error) {
@@ -59,6 +149,8 @@ func scanPeople(sc dbutil.Scanner) (*types.People, error) {
 	if err := sc.Scan(
 		&person.ID,
 		&person.UserID,
+		&person.Version,
+		&person.DeletedAt,
 	); err != nil {
 		return nil, err
 	}
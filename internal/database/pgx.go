@@ -2,56 +2,96 @@ package database
 
 import (
 	"context"
-	"log"
-	"time"
+	"log/slog"
 
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func createPgxPoolConfig(logger *log.Logger) *pgxpool.Config {
-	const defaultMaxConns = int32(4)
-	const defaultMinConns = int32(1)
-	const defaultMaxConnLifetime = time.Hour
-	const defaultMaxConnIdleTime = time.Minute * 30
-	const defaultHealthCheckPeriod = time.Minute
-	const defaultConnectTimeout = time.Second * 5
-
-	dbConfig, err := pgxpool.ParseConfig(dsn)
+func createPgxPoolConfig(logger *slog.Logger, cfg config.Database, creds *credentialCache) (*pgxpool.Config, error) {
+	dbConfig, err := pgxpool.ParseConfig(cfg.DSN())
 	if err != nil {
-		logger.Fatal("Failed to create a config, error: ", err)
+		return nil, err
 	}
+	setServerName(dbConfig.ConnConfig, cfg)
+
+	dbConfig.MaxConns = cfg.MaxConns
+	dbConfig.MinConns = cfg.MinConns
+	dbConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	dbConfig.MaxConnLifetimeJitter = cfg.MaxConnLifetimeJitter
+	dbConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	dbConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	dbConfig.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
 
-	dbConfig.MaxConns = defaultMaxConns
-	dbConfig.MinConns = defaultMinConns
-	dbConfig.MaxConnLifetime = defaultMaxConnLifetime
-	dbConfig.MaxConnIdleTime = defaultMaxConnIdleTime
-	dbConfig.HealthCheckPeriod = defaultHealthCheckPeriod
-	dbConfig.ConnConfig.ConnectTimeout = defaultConnectTimeout
+	logger = logger.With(slog.String("component", "pgxpool"))
 
 	dbConfig.BeforeAcquire = func(ctx context.Context, c *pgx.Conn) bool {
-		logger.Println("Before acquiring the connection pool to the database!!")
+		if creds.stale(c, cfg.MaxConnLifetimeJitter) {
+			logger.InfoContext(ctx, "replacing connection opened with rotated credentials", "pid", c.PgConn().PID())
+			return false
+		}
+		logger.DebugContext(ctx, "acquiring connection", "pid", c.PgConn().PID())
 		return true
 	}
 
 	dbConfig.AfterRelease = func(c *pgx.Conn) bool {
-		logger.Println("After releasing the connection pool to the database!!")
+		logger.Debug("released connection", "pid", c.PgConn().PID())
 		return true
 	}
 
 	dbConfig.BeforeClose = func(c *pgx.Conn) {
-		logger.Println("Closed the connection pool to the database!!")
+		logger.Debug("closing connection", "pid", c.PgConn().PID())
 	}
 
+	certs := newCertReloader(logger, cfg)
 	dbConfig.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
-		logger.Println("Before connecting to database")
+		if err := creds.apply(ctx, cc); err != nil {
+			return err
+		}
+		logger.DebugContext(ctx, "connecting", "host", cc.Host, "database", cc.Database, "user", cc.User)
+		if certs != nil {
+			return certs.apply(cc)
+		}
 		return nil
 	}
 
 	dbConfig.AfterConnect = func(ctx context.Context, c *pgx.Conn) error {
-		logger.Println("after connecting to database!!")
+		logger.DebugContext(ctx, "connected", "pid", c.PgConn().PID())
 		return nil
 	}
 
-	return dbConfig
+	return dbConfig, nil
+}
+
+// ConnConfig returns the connection settings New uses, including the current
+// credentials, for tools that need connections of their own, e.g. to another
+// database on the same server.
+func ConnConfig(ctx context.Context, logger *slog.Logger, cfg config.Database) (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	setServerName(connConfig, cfg)
+	if err := newCredentialCache(logger, cfg).apply(ctx, connConfig); err != nil {
+		return nil, err
+	}
+	return connConfig, nil
+}
+
+// setServerName makes TLS verify the server certificate against the
+// configured name rather than the host connected to, e.g. when connecting
+// through a proxy.
+func setServerName(connConfig *pgx.ConnConfig, cfg config.Database) {
+	if cfg.SSLServerName == "" {
+		return
+	}
+	if connConfig.TLSConfig != nil {
+		connConfig.TLSConfig.ServerName = cfg.SSLServerName
+	}
+	for _, fallback := range connConfig.Fallbacks {
+		if fallback.TLSConfig != nil {
+			fallback.TLSConfig.ServerName = cfg.SSLServerName
+		}
+	}
 }
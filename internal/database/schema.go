@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/keegancsmith/sqlf"
+)
+
+// storeSchema is what a store expects of its table: the columns it selects,
+// the Go types its scan function reads them into, and the columns it inserts.
+type storeSchema struct {
+	table         string
+	columns       []*sqlf.Query
+	insertColumns []*sqlf.Query
+	scanTypes     []reflect.Type
+}
+
+func scanTypes[T any](scan func(dbutil.Scanner) (T, error)) []reflect.Type {
+	return dbutil.ScanTypes(func(sc dbutil.Scanner) error {
+		_, err := scan(sc)
+		return err
+	})
+}
+
+var storeSchemas = []storeSchema{
+	{"users", userColumns, userInsertColumns, scanTypes(scanUser)},
+	{"people", peopleColumns, peopleInsertColumns, scanTypes(scanPeople)},
+	{"audit_log", auditColumns, auditInsertColumns, scanTypes(scanAuditEntry)},
+	{"outbox", outboxColumns, outboxInsertColumns, scanTypes(scanOutboxMessage)},
+	{"jobs", jobColumns, jobInsertColumns, scanTypes(scanJob)},
+}
+
+// compatibleDataTypes lists, for each type we scan into, the information_schema
+// data types pgx can scan into it.
+var compatibleDataTypes = map[reflect.Type][]string{
+	reflect.TypeOf(""):                {"uuid", "text", "character varying", "character", "citext"},
+	reflect.TypeOf(int64(0)):          {"bigint", "integer", "smallint"},
+	reflect.TypeOf(int32(0)):          {"integer", "smallint"},
+	reflect.TypeOf(int16(0)):          {"smallint"},
+	reflect.TypeOf(false):             {"boolean"},
+	reflect.TypeOf(time.Time{}):       {"timestamp with time zone", "timestamp without time zone", "date"},
+	reflect.TypeOf(json.RawMessage{}): {"json", "jsonb"},
+	reflect.TypeOf([]byte{}):          {"bytea", "json", "jsonb"},
+}
+
+type columnInfo struct {
+	dataType string
+	nullable bool
+}
+
+const schemaColumnsQuery = `
+SELECT table_name, column_name, data_type, is_nullable = 'YES'
+FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = ANY($1)
+`
+
+// VerifySchema checks that every table a store reads or writes has the columns
+// the store refers to, with types its scan function can read. It returns a
+// single error listing every mismatch, so a missing migration shows up at
+// startup instead of as a scan error on the first request.
+func VerifySchema(ctx context.Context, db DB) error {
+	tables := make([]string, 0, len(storeSchemas))
+	for _, s := range storeSchemas {
+		tables = append(tables, s.table)
+	}
+
+	rows, err := db.Query(ctx, schemaColumnsQuery, tables)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	actual := map[string]map[string]columnInfo{}
+	for rows.Next() {
+		var table, column string
+		var info columnInfo
+		if err := rows.Scan(&table, &column, &info.dataType, &info.nullable); err != nil {
+			return err
+		}
+		if actual[table] == nil {
+			actual[table] = map[string]columnInfo{}
+		}
+		actual[table][column] = info
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, s := range storeSchemas {
+		problems = append(problems, s.verify(actual[s.table])...)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("database schema does not match the stores (is a migration missing?):\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func (s storeSchema) verify(actual map[string]columnInfo) []string {
+	if actual == nil {
+		return []string{fmt.Sprintf("table %s does not exist", s.table)}
+	}
+
+	var problems []string
+	if len(s.scanTypes) != len(s.columns) {
+		problems = append(problems, fmt.Sprintf("%s: store selects %d columns but scans %d", s.table, len(s.columns), len(s.scanTypes)))
+	}
+
+	for i, col := range s.columns {
+		name := columnName(col)
+		info, ok := actual[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s.%s: column does not exist", s.table, name))
+			continue
+		}
+		if i >= len(s.scanTypes) {
+			continue
+		}
+
+		goType := s.scanTypes[i]
+		nullable := goType.Kind() == reflect.Pointer || goType.Kind() == reflect.Slice
+		if goType.Kind() == reflect.Pointer {
+			goType = goType.Elem()
+		}
+
+		if compatible, known := compatibleDataTypes[goType]; known && !contains(compatible, info.dataType) {
+			problems = append(problems, fmt.Sprintf("%s.%s: %s can't be scanned into %s", s.table, name, info.dataType, goType))
+		}
+		if info.nullable && !nullable {
+			problems = append(problems, fmt.Sprintf("%s.%s: column is nullable but is scanned into %s", s.table, name, goType))
+		}
+	}
+
+	for _, col := range s.insertColumns {
+		if name := columnName(col); !hasColumn(actual, name) {
+			problems = append(problems, fmt.Sprintf("%s.%s: inserted column does not exist", s.table, name))
+		}
+	}
+	return problems
+}
+
+// columnName returns the bare column name a column list entry refers to, e.g.
+// id for users.id.
+func columnName(col *sqlf.Query) string {
+	name := col.Query(sqlf.PostgresBindVar)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(name), `"`)
+}
+
+func hasColumn(columns map[string]columnInfo, name string) bool {
+	_, ok := columns[name]
+	return ok
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
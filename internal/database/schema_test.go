@@ -0,0 +1,109 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keegancsmith/sqlf"
+)
+
+func TestStoreSchemaVerify(t *testing.T) {
+	schema := storeSchema{
+		table: "widgets",
+		columns: []*sqlf.Query{
+			sqlf.Sprintf("widgets.id"),
+			sqlf.Sprintf("widgets.name"),
+			sqlf.Sprintf("widgets.deleted_at"),
+		},
+		insertColumns: []*sqlf.Query{sqlf.Sprintf("name")},
+		scanTypes: []reflect.Type{
+			reflect.TypeOf(int64(0)),
+			reflect.TypeOf(""),
+			reflect.TypeOf(&time.Time{}),
+		},
+	}
+	// catalog is what the migrations create for the table.
+	catalog := func() map[string]columnInfo {
+		return map[string]columnInfo{
+			"id":         {dataType: "bigint"},
+			"name":       {dataType: "text"},
+			"deleted_at": {dataType: "timestamp with time zone", nullable: true},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		schema storeSchema
+		change func(actual map[string]columnInfo) map[string]columnInfo
+		want   []string
+	}{
+		{name: "matching"},
+		{
+			name: "extra column",
+			change: func(actual map[string]columnInfo) map[string]columnInfo {
+				actual["added_later"] = columnInfo{dataType: "text"}
+				return actual
+			},
+		},
+		{
+			name:   "missing table",
+			change: func(map[string]columnInfo) map[string]columnInfo { return nil },
+			want:   []string{"table widgets does not exist"},
+		},
+		{
+			name: "missing column",
+			change: func(actual map[string]columnInfo) map[string]columnInfo {
+				delete(actual, "name")
+				return actual
+			},
+			want: []string{"widgets.name: column does not exist", "widgets.name: inserted column does not exist"},
+		},
+		{
+			name: "mistyped column",
+			change: func(actual map[string]columnInfo) map[string]columnInfo {
+				actual["id"] = columnInfo{dataType: "uuid"}
+				actual["deleted_at"] = columnInfo{dataType: "text", nullable: true}
+				return actual
+			},
+			want: []string{
+				"widgets.id: uuid can't be scanned into int64",
+				"widgets.deleted_at: text can't be scanned into time.Time",
+			},
+		},
+		{
+			name: "nullable column",
+			change: func(actual map[string]columnInfo) map[string]columnInfo {
+				actual["name"] = columnInfo{dataType: "text", nullable: true}
+				return actual
+			},
+			want: []string{"widgets.name: column is nullable but is scanned into string"},
+		},
+		{
+			name: "scan function out of step",
+			schema: storeSchema{
+				table:     schema.table,
+				columns:   schema.columns,
+				scanTypes: schema.scanTypes[:2],
+			},
+			want: []string{"widgets: store selects 3 columns but scans 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := schema
+			if tt.schema.table != "" {
+				s = tt.schema
+			}
+			actual := catalog()
+			if tt.change != nil {
+				actual = tt.change(actual)
+			}
+
+			if got := s.verify(actual); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got problems\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
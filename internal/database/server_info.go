@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// ServerInfo describes the database server we are connected to.
+type ServerInfo struct {
+	Version string `json:"version"`
+	// InRecovery is set when the server is a standby.
+	InRecovery bool `json:"inRecovery"`
+	// Replicas lists the standbys streaming from the server. It is empty unless
+	// replicas are configured.
+	Replicas []Replica `json:"replicas,omitempty"`
+}
+
+// Replica is a standby streaming from the server, as seen in
+// pg_stat_replication.
+type Replica struct {
+	ApplicationName string `json:"applicationName"`
+	ClientAddr      string `json:"clientAddr"`
+	State           string `json:"state"`
+	// ReplayLag is how far the replica is behind in applying changes. It is nil
+	// until the replica has reported its progress.
+	ReplayLag *time.Duration `json:"replayLag"`
+}
+
+// GetServerInfo returns the version of the server along with its replicas and
+// how far they lag behind.
+func GetServerInfo(ctx context.Context, db DB) (*ServerInfo, error) {
+	var info ServerInfo
+	err := db.QueryRow(ctx, "SELECT current_setting('server_version'), pg_is_in_recovery()").Scan(&info.Version, &info.InRecovery)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+SELECT application_name, coalesce(host(client_addr), ''), coalesce(state, ''), replay_lag
+FROM pg_stat_replication
+ORDER BY application_name, client_addr`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Replica
+		if err := rows.Scan(&r.ApplicationName, &r.ClientAddr, &r.State, &r.ReplayLag); err != nil {
+			return nil, err
+		}
+		info.Replicas = append(info.Replicas, r)
+	}
+	return &info, rows.Err()
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// certReloader keeps the TLS configs of new connections in step with the
+// certificate files named in the config, reloading them whenever they change
+// on disk so that rotated certificates are picked up without a restart.
+type certReloader struct {
+	rootCertFile string
+	certFile     string
+	keyFile      string
+	logger       *slog.Logger
+
+	mu sync.Mutex
+	// loaded identifies the versions of the files last loaded.
+	loaded       []fileVersion
+	rootCAs      *x509.CertPool
+	certificates []tls.Certificate
+}
+
+// fileVersion identifies the contents of a file without reading it.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statVersion(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func (v fileVersion) equal(other fileVersion) bool {
+	return v.modTime.Equal(other.modTime) && v.size == other.size
+}
+
+// newCertReloader returns a reloader for the certificate files in cfg, or nil
+// if there are none.
+func newCertReloader(logger *slog.Logger, cfg config.Database) *certReloader {
+	if cfg.SSLRootCert == "" && cfg.SSLCert == "" {
+		return nil
+	}
+	return &certReloader{
+		rootCertFile: cfg.SSLRootCert,
+		certFile:     cfg.SSLCert,
+		keyFile:      cfg.SSLKey,
+		logger:       logger,
+	}
+}
+
+// apply sets the current certificates on the TLS configs cc will try,
+// reloading the files first if any of them has changed.
+func (r *certReloader) apply(cc *pgx.ConnConfig) error {
+	rootCAs, certificates, err := r.current()
+	if err != nil {
+		return err
+	}
+
+	tlsConfigs := []*tls.Config{cc.TLSConfig}
+	for _, fallback := range cc.Fallbacks {
+		tlsConfigs = append(tlsConfigs, fallback.TLSConfig)
+	}
+	for _, tlsConfig := range tlsConfigs {
+		if tlsConfig == nil {
+			continue
+		}
+		if rootCAs != nil {
+			tlsConfig.RootCAs = rootCAs
+			// For sslmode=verify-ca pgx verifies the chain itself, against
+			// the roots it loaded when the config was parsed.
+			if tlsConfig.VerifyPeerCertificate != nil {
+				tlsConfig.VerifyPeerCertificate = verifyChain(rootCAs)
+			}
+		}
+		if certificates != nil {
+			tlsConfig.Certificates = certificates
+		}
+	}
+	return nil
+}
+
+// current returns the certificates, reloading them if the files have changed
+// since they were last loaded. If reloading fails, e.g. because only some of
+// the files have been replaced so far, the certificates loaded before are
+// kept.
+func (r *certReloader) current() (*x509.CertPool, []tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.versions()
+	if err == nil && r.unchanged(versions) {
+		return r.rootCAs, r.certificates, nil
+	}
+
+	var rootCAs *x509.CertPool
+	var certificates []tls.Certificate
+	if err == nil {
+		rootCAs, certificates, err = r.load()
+	}
+	if err != nil {
+		if r.loaded == nil {
+			return nil, nil, err
+		}
+		r.logger.Warn("error reloading TLS certificates, using the previous ones", "error", err)
+		return r.rootCAs, r.certificates, nil
+	}
+
+	if r.loaded != nil {
+		r.logger.Info("reloaded TLS certificates", "rootCert", r.rootCertFile, "cert", r.certFile)
+	}
+	r.loaded, r.rootCAs, r.certificates = versions, rootCAs, certificates
+	return rootCAs, certificates, nil
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	for _, f := range []string{r.rootCertFile, r.certFile, r.keyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (r *certReloader) versions() ([]fileVersion, error) {
+	var versions []fileVersion
+	for _, f := range r.files() {
+		version, err := statVersion(f)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (r *certReloader) unchanged(versions []fileVersion) bool {
+	if len(versions) != len(r.loaded) {
+		return false
+	}
+	for i := range versions {
+		if !versions[i].equal(r.loaded[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *certReloader) load() (*x509.CertPool, []tls.Certificate, error) {
+	var rootCAs *x509.CertPool
+	if r.rootCertFile != "" {
+		pem, err := os.ReadFile(r.rootCertFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading root certificates: %w", err)
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", r.rootCertFile)
+		}
+	}
+
+	var certificates []tls.Certificate
+	if r.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		certificates = []tls.Certificate{cert}
+	}
+	return rootCAs, certificates, nil
+}
+
+// verifyChain verifies the server's certificate chain against roots without
+// checking its host name, as sslmode=verify-ca requires.
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server sent no certificates")
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse certificate from server: %w", err)
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				opts.Intermediates.AddCert(cert)
+			}
+		}
+		_, err := leaf.Verify(opts)
+		return err
+	}
+}
@@ -0,0 +1,326 @@
+package database
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns a leaf certificate for name signed by the CA, and its key, in
+// PEM, along with the certificate in DER.
+func (ca *testCA) issue(t *testing.T, name string) (certPEM, keyPEM, der []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, der
+}
+
+// writeFile writes data to path and sets its modification time, so that tests
+// don't depend on the file system's timestamp resolution to tell writes apart.
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func leafOf(t *testing.T, certificates []tls.Certificate) []byte {
+	t.Helper()
+
+	if len(certificates) != 1 {
+		t.Fatalf("got %d client certificates, want 1", len(certificates))
+	}
+	return certificates[0].Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "root.crt")
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+
+	ca := newTestCA(t, "test CA")
+	certPEM, keyPEM, firstDER := ca.issue(t, "client")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, rootFile, ca.pem, modTime)
+	writeFile(t, certFile, certPEM, modTime)
+	writeFile(t, keyFile, keyPEM, modTime)
+
+	r := newCertReloader(testLogger, config.Database{SSLRootCert: rootFile, SSLCert: certFile, SSLKey: keyFile})
+
+	_, certificates, err := r.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := leafOf(t, certificates); string(got) != string(firstDER) {
+		t.Fatal("initial load returned the wrong client certificate")
+	}
+
+	t.Run("only some files replaced", func(t *testing.T) {
+		// A new certificate without its key can't be loaded, so the
+		// certificate loaded before is kept until the key arrives.
+		newCertPEM, _, _ := ca.issue(t, "client")
+		modTime = modTime.Add(time.Minute)
+		writeFile(t, certFile, newCertPEM, modTime)
+
+		_, certificates, err := r.current()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := leafOf(t, certificates); string(got) != string(firstDER) {
+			t.Error("a half-replaced key pair was loaded")
+		}
+	})
+
+	t.Run("reload after files change", func(t *testing.T) {
+		otherCA := newTestCA(t, "rotated CA")
+		certPEM, keyPEM, secondDER := otherCA.issue(t, "client")
+		modTime = modTime.Add(time.Minute)
+		writeFile(t, rootFile, otherCA.pem, modTime)
+		writeFile(t, certFile, certPEM, modTime)
+		writeFile(t, keyFile, keyPEM, modTime)
+
+		rootCAs, certificates, err := r.current()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := leafOf(t, certificates); string(got) != string(secondDER) {
+			t.Error("the rotated client certificate was not loaded")
+		}
+		if !rootCAs.Equal(otherCA.pool()) {
+			t.Error("the rotated root certificate was not loaded")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := os.Remove(rootFile); err != nil {
+			t.Fatal(err)
+		}
+		rootCAs, certificates, err := r.current()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rootCAs == nil || len(certificates) != 1 {
+			t.Error("the previous certificates were not kept")
+		}
+	})
+}
+
+func TestCertReloaderInitialLoadFails(t *testing.T) {
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "root.crt")
+	writeFile(t, rootFile, []byte("not a certificate"), time.Now())
+
+	r := newCertReloader(testLogger, config.Database{SSLRootCert: rootFile})
+	if _, _, err := r.current(); err == nil {
+		t.Error("expected an error when nothing has been loaded yet")
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	_, _, trusted := ca.issue(t, "db.internal")
+	_, _, foreign := newTestCA(t, "foreign CA").issue(t, "db.internal")
+
+	verify := verifyChain(ca.pool())
+
+	// verify-ca checks the chain but not the host name, so a certificate for
+	// another host signed by the CA is accepted.
+	if err := verify([][]byte{trusted}, nil); err != nil {
+		t.Errorf("certificate from the CA rejected: %v", err)
+	}
+	if err := verify([][]byte{foreign}, nil); err == nil {
+		t.Error("certificate from a foreign CA accepted")
+	}
+	if err := verify(nil, nil); err == nil {
+		t.Error("no certificates accepted")
+	}
+}
+
+func TestCertReloaderApplyVerifyCA(t *testing.T) {
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "root.crt")
+
+	oldCA := newTestCA(t, "old CA")
+	writeFile(t, rootFile, oldCA.pem, time.Now().Add(-time.Hour))
+
+	cc, err := pgx.ParseConfig("host=db.internal sslmode=verify-ca sslrootcert=" + rootFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.TLSConfig == nil || cc.TLSConfig.VerifyPeerCertificate == nil {
+		t.Fatal("expected pgx to verify the chain itself for verify-ca")
+	}
+
+	newCA := newTestCA(t, "new CA")
+	writeFile(t, rootFile, newCA.pem, time.Now())
+
+	r := newCertReloader(testLogger, config.Database{SSLRootCert: rootFile})
+	if err := r.apply(cc); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, fromNew := newCA.issue(t, "db.internal")
+	_, _, fromOld := oldCA.issue(t, "db.internal")
+	if err := cc.TLSConfig.VerifyPeerCertificate([][]byte{fromNew}, nil); err != nil {
+		t.Errorf("certificate from the reloaded CA rejected: %v", err)
+	}
+	if err := cc.TLSConfig.VerifyPeerCertificate([][]byte{fromOld}, nil); err == nil {
+		t.Error("certificate from the replaced CA still accepted")
+	}
+}
+
+// TestTLSConnection connects to a local Postgres configured for SSL, as
+// described by the usual PG* environment variables (PGSSLMODE=verify-ca or
+// verify-full, PGSSLROOTCERT, and PGSSLCERT and PGSSLKEY if the server asks
+// for client certificates). It only runs if PGX_STORE_TEST_SSL is set.
+func TestTLSConnection(t *testing.T) {
+	if os.Getenv("PGX_STORE_TEST_SSL") == "" {
+		t.Skip("PGX_STORE_TEST_SSL not set")
+	}
+
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := cfg.Database
+	if d.SSLMode != "verify-ca" && d.SSLMode != "verify-full" {
+		t.Fatalf("PGSSLMODE must be verify-ca or verify-full, got %q", d.SSLMode)
+	}
+
+	// Work on copies of the root certificate so that it can be swapped out.
+	rootPEM, err := os.ReadFile(d.SSLRootCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SSLRootCert = filepath.Join(t.TempDir(), "root.crt")
+	writeFile(t, d.SSLRootCert, rootPEM, time.Now().Add(-time.Hour))
+
+	poolConfig, err := createPgxPoolConfig(testLogger, d, newCredentialCache(testLogger, d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolConfig.MinConns = 0
+
+	ctx := context.Background()
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var ssl bool
+	var clientDN *string
+	err = pool.QueryRow(ctx, "SELECT ssl, client_dn FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl, &clientDN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ssl {
+		t.Error("connection is not using SSL")
+	}
+	if d.SSLCert != "" && (clientDN == nil || *clientDN == "") {
+		t.Error("server did not see the client certificate")
+	}
+
+	// New connections pick up a replaced root certificate: one the server's
+	// certificate isn't signed by makes them fail, and putting the right one
+	// back makes them work again.
+	writeFile(t, d.SSLRootCert, newTestCA(t, "foreign CA").pem, time.Now().Add(-time.Minute))
+	pool.Reset()
+	if err := pool.Ping(ctx); err == nil {
+		t.Error("connected with a root certificate that doesn't sign the server's")
+	}
+
+	writeFile(t, d.SSLRootCert, rootPEM, time.Now())
+	pool.Reset()
+	if err := pool.Ping(ctx); err != nil {
+		t.Errorf("connecting after restoring the root certificate: %v", err)
+	}
+}
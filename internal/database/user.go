@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
@@ -12,7 +14,10 @@ import (
 	"github.com/keegancsmith/sqlf"
 )
 
-const defaultUserLimit = 10
+const (
+	defaultUserLimit = 10
+	maxUserLimit     = 100
+)
 
 type userNotFoundErr struct {
 	ID    string
@@ -26,12 +31,41 @@ func (e userNotFoundErr) Error() string {
 	return fmt.Sprintf("user with email %s not found", e.Email)
 }
 
+// staleWriteErr is returned by UserStore.Update when the row's version no
+// longer matches the version the caller read, meaning someone else has
+// written to it in the meantime.
+type staleWriteErr struct {
+	ID              string
+	ExpectedVersion int32
+}
+
+func (e staleWriteErr) Error() string {
+	return fmt.Sprintf("user with ID %s is not at version %d", e.ID, e.ExpectedVersion)
+}
+
+// userConflictErr is returned by UserStore.Update when the new username or
+// email is already taken by another user.
+type userConflictErr struct {
+	Field string
+	Value string
+}
+
+func (e userConflictErr) Error() string {
+	return fmt.Sprintf("another user already has %s %s", e.Field, e.Value)
+}
+
 var userColumns = []*sqlf.Query{
 	sqlf.Sprintf("users.id"),
 	sqlf.Sprintf("users.username"),
 	sqlf.Sprintf("users.email"),
+	sqlf.Sprintf("users.version"),
+	sqlf.Sprintf("users.created_at"),
+	sqlf.Sprintf("users.deleted_at"),
 }
 
+// userLiveCond excludes soft-deleted users.
+var userLiveCond = basestore.IsNull("users.deleted_at")
+
 var userInsertColumns = []*sqlf.Query{
 	sqlf.Sprintf("username"),
 	sqlf.Sprintf("email"),
@@ -41,13 +75,142 @@ type UserStore interface {
 	basestore.ShareableStore
 
 	List(ctx context.Context, opts ListUserArgs) ([]*types.User, error)
+	// ListPage is List, also describing where the page sits among all the
+	// users matching opts.
+	ListPage(ctx context.Context, opts ListUserArgs) ([]*types.User, PageInfo, error)
 	GetByID(ctx context.Context, userID string) (*types.User, error)
 	GetByEmail(ctx context.Context, email string) (*types.User, error)
 	Create(ctx context.Context, email string, username string) (*types.User, error)
+	// CreateWithID is Create with a caller-chosen ID instead of a generated one,
+	// for fixtures that need the same IDs every time they are loaded.
+	CreateWithID(ctx context.Context, id string, email string, username string) (*types.User, error)
+	// Update applies the changes in update to the user and returns the result.
+	// A change to an email or username another user already has fails.
+	Update(ctx context.Context, userID string, update UserUpdate) (*types.User, error)
+
+	// Delete soft-deletes the user along with their person record. Deleted users
+	// are hidden from every read unless explicitly asked for, and can be brought
+	// back with Restore.
+	Delete(ctx context.Context, userID string) error
+	// Restore undoes Delete. It fails if the user's username or email has been
+	// taken by someone else in the meantime.
+	Restore(ctx context.Context, userID string) (*types.User, error)
+	// Purge permanently removes the user and their person record, whether or not
+	// they were soft-deleted first.
+	Purge(ctx context.Context, userID string) error
 }
 
+// ListUserArgs selects a page of users, which are ordered by username and
+// then ID. Pages are picked by a cursor, or by an offset for admin tooling
+// that wants to jump around, at the cost of pages shifting as users are
+// created and deleted.
 type ListUserArgs struct {
-	Limit int
+	Limit  int
+	Offset int
+
+	// After restricts the results to users ordered after the cursor, and
+	// Before to those ordered before it. With only Before set, the page is the
+	// last Limit users before it rather than the first Limit users.
+	After  *UserCursor
+	Before *UserCursor
+
+	// IDs restricts the results to users with the given IDs, if set.
+	IDs []string
+	// Usernames restricts the results to users with the given usernames, if set.
+	Usernames []string
+	// UsernamePrefix restricts the results to users whose username starts with
+	// it, and UsernameContains to those whose username contains it, ignoring
+	// case.
+	UsernamePrefix   string
+	UsernameContains string
+	// Email restricts the results to the user with this email, EmailDomain to
+	// users with an email at this domain, and EmailContains to users whose
+	// email contains it. All of them ignore case.
+	Email         string
+	EmailDomain   string
+	EmailContains string
+	// CreatedSince and CreatedUntil restrict the results to users created in
+	// [CreatedSince, CreatedUntil), when set.
+	CreatedSince time.Time
+	CreatedUntil time.Time
+	// IncludeDeleted includes soft-deleted users in the results.
+	IncludeDeleted bool
+}
+
+// UserCursor is the position of a user in the order users are listed in.
+type UserCursor struct {
+	Username string `json:"u"`
+	ID       string `json:"i"`
+}
+
+func userCursor(user *types.User) *UserCursor {
+	return &UserCursor{Username: user.Username, ID: user.ID}
+}
+
+// PageInfo describes a page of results. StartCursor and EndCursor are the
+// positions of the first and last results, and are nil when there are none.
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     *UserCursor
+	EndCursor       *UserCursor
+}
+
+// UserUpdate describes changes to a user. Fields left nil are not changed.
+type UserUpdate struct {
+	Email    *string
+	Username *string
+
+	// ExpectedVersion, if set, makes the update fail with a stale write error
+	// (see IsStaleWriteErr) unless the user is still at this version.
+	ExpectedVersion *int32
+}
+
+// conds returns the WHERE conditions described by the arguments.
+func (a ListUserArgs) conds() []*sqlf.Query {
+	var conds []*sqlf.Query
+	if !a.IncludeDeleted {
+		conds = append(conds, userLiveCond)
+	}
+	if len(a.IDs) > 0 {
+		conds = append(conds, basestore.Any("users.id", a.IDs))
+	}
+	if len(a.Usernames) > 0 {
+		conds = append(conds, basestore.Any("users.username", a.Usernames))
+	}
+	if a.UsernamePrefix != "" {
+		conds = append(conds, basestore.Like("users.username", basestore.EscapeLike(a.UsernamePrefix)+"%"))
+	}
+	if a.UsernameContains != "" {
+		conds = append(conds, basestore.ILike("users.username", "%"+basestore.EscapeLike(a.UsernameContains)+"%"))
+	}
+	if a.Email != "" {
+		conds = append(conds, sqlf.Sprintf("lower(users.email) = lower(%s)", a.Email))
+	}
+	if a.EmailDomain != "" {
+		conds = append(conds, sqlf.Sprintf("lower(split_part(users.email, '@', 2)) = lower(%s)", a.EmailDomain))
+	}
+	if a.EmailContains != "" {
+		conds = append(conds, basestore.ILike("users.email", "%"+basestore.EscapeLike(a.EmailContains)+"%"))
+	}
+	if !a.CreatedSince.IsZero() {
+		conds = append(conds, basestore.Gte("users.created_at", a.CreatedSince))
+	}
+	if !a.CreatedUntil.IsZero() {
+		conds = append(conds, basestore.Lt("users.created_at", a.CreatedUntil))
+	}
+	if a.After != nil {
+		conds = append(conds, sqlf.Sprintf("(users.username, users.id) > (%s, %s)", a.After.Username, a.After.ID))
+	}
+	if a.Before != nil {
+		conds = append(conds, sqlf.Sprintf("(users.username, users.id) < (%s, %s)", a.Before.Username, a.Before.ID))
+	}
+	return conds
+}
+
+// backward reports whether the page is counted back from Before.
+func (a ListUserArgs) backward() bool {
+	return a.Before != nil && a.After == nil
 }
 
 func UsersWith(other basestore.ShareableStore) UserStore {
@@ -60,25 +223,42 @@ type userStore struct {
 
 var _ UserStore = &userStore{}
 
-const listUsersFmtStr = `
-SELECT %s FROM users
-LIMIT %s
-`
-
 func (u *userStore) List(ctx context.Context, opts ListUserArgs) ([]*types.User, error) {
-	if opts.Limit == 0 {
+	users, _, err := u.ListPage(ctx, opts)
+	return users, err
+}
+
+func (u *userStore) ListPage(ctx context.Context, opts ListUserArgs) ([]*types.User, PageInfo, error) {
+	if opts.Limit <= 0 {
 		opts.Limit = defaultUserLimit
 	}
+	if opts.Limit > maxUserLimit {
+		opts.Limit = maxUserLimit
+	}
+	if opts.Offset < 0 {
+		return nil, PageInfo{}, errors.New("offset must not be negative")
+	}
+	if opts.Offset > 0 && (opts.After != nil || opts.Before != nil) {
+		return nil, PageInfo{}, errors.New("offset can't be combined with a cursor")
+	}
 
-	query := sqlf.Sprintf(
-		listUsersFmtStr,
-		sqlf.Join(userColumns, ", "),
-		opts.Limit,
-	)
+	order := []*sqlf.Query{basestore.Asc("users.username"), basestore.Asc("users.id")}
+	if opts.backward() {
+		order = []*sqlf.Query{basestore.Desc("users.username"), basestore.Desc("users.id")}
+	}
+
+	// Fetch one more user than asked for to tell whether there is another page.
+	query := basestore.Select(userColumns...).
+		From("users").
+		Where(opts.conds()...).
+		OrderBy(order...).
+		Limit(opts.Limit + 1).
+		Offset(opts.Offset).
+		Query()
 
 	rows, err := u.Query(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, PageInfo{}, err
 	}
 	defer rows.Close()
 
@@ -95,24 +275,42 @@ func (u *userStore) List(ctx context.Context, opts ListUserArgs) ([]*types.User,
 
 	for rows.Next() {
 		if err := scanUserFunc(rows); err != nil {
-			return nil, err
+			return nil, PageInfo{}, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
 
-	return users, rows.Err()
-}
+	more := len(users) > opts.Limit
+	if more {
+		users = users[:opts.Limit]
+	}
 
-const getUserFmtStr = `
-SELECT %s FROM users
-WHERE %s
-LIMIT 1`
+	// Whether there is anything on the other side of a cursor isn't checked,
+	// as it was there when the cursor was handed out.
+	var info PageInfo
+	if opts.backward() {
+		slices.Reverse(users)
+		info.HasPreviousPage = more
+		info.HasNextPage = true
+	} else {
+		info.HasNextPage = more
+		info.HasPreviousPage = opts.After != nil || opts.Offset > 0
+	}
+	if len(users) > 0 {
+		info.StartCursor = userCursor(users[0])
+		info.EndCursor = userCursor(users[len(users)-1])
+	}
+	return users, info, nil
+}
 
-func (u *userStore) get(ctx context.Context, whereClause *sqlf.Query) (*types.User, error) {
-	q := sqlf.Sprintf(
-		getUserFmtStr,
-		sqlf.Join(userColumns, ", "),
-		whereClause,
-	)
+func (u *userStore) get(ctx context.Context, conds ...*sqlf.Query) (*types.User, error) {
+	q := basestore.Select(userColumns...).
+		From("users").
+		Where(conds...).
+		Limit(1).
+		Query()
 
 	return scanUser(u.QueryRow(ctx, q))
 }
@@ -122,7 +320,19 @@ func (u *userStore) GetByID(ctx context.Context, userID string) (*types.User, er
 		return nil, errors.New("no user id provided")
 	}
 
-	user, err := u.get(ctx, sqlf.Sprintf("id = %s", userID))
+	user, err := u.get(ctx, basestore.Eq("id", userID), userLiveCond)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, userNotFoundErr{ID: userID}
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// getByIDIncludingDeleted is GetByID without hiding soft-deleted users.
+func (u *userStore) getByIDIncludingDeleted(ctx context.Context, userID string) (*types.User, error) {
+	user, err := u.get(ctx, basestore.Eq("id", userID))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, userNotFoundErr{ID: userID}
@@ -137,7 +347,7 @@ func (u *userStore) GetByEmail(ctx context.Context, email string) (*types.User,
 		return nil, errors.New("no email provided")
 	}
 
-	user, err := u.get(ctx, sqlf.Sprintf("email = %s", email))
+	user, err := u.get(ctx, basestore.Eq("email", email), userLiveCond)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, userNotFoundErr{Email: email}
@@ -147,17 +357,22 @@ func (u *userStore) GetByEmail(ctx context.Context, email string) (*types.User,
 	return user, nil
 }
 
-const userCreateQueryFmtStr = `
-INSERT INTO
-	users (%s)
-	VALUES (
-		%s,
-		%s
-	)
-	RETURNING %s
-`
-
 func (u *userStore) Create(ctx context.Context, email string, username string) (*types.User, error) {
+	ctx = withMethod(ctx, "users", "Create")
+
+	return u.create(ctx, "", email, username)
+}
+
+func (u *userStore) CreateWithID(ctx context.Context, id string, email string, username string) (*types.User, error) {
+	ctx = withMethod(ctx, "users", "CreateWithID")
+
+	if id == "" {
+		return nil, errors.New("no user id provided")
+	}
+	return u.create(ctx, id, email, username)
+}
+
+func (u *userStore) create(ctx context.Context, id string, email string, username string) (*types.User, error) {
 	if email == "" {
 		return nil, errors.New("no email provided")
 	}
@@ -166,15 +381,211 @@ func (u *userStore) Create(ctx context.Context, email string, username string) (
 		return nil, errors.New("no username provided")
 	}
 
-	q := sqlf.Sprintf(
-		userCreateQueryFmtStr,
-		sqlf.Join(userInsertColumns, ", "),
-		username,
-		email,
-		sqlf.Join(userColumns, ", "),
-	)
+	columns, values := userInsertColumns, []any{username, email}
+	if id != "" {
+		columns = append([]*sqlf.Query{sqlf.Sprintf("id")}, columns...)
+		values = append([]any{id}, values...)
+	}
+
+	q := basestore.Insert("users").
+		Columns(columns...).
+		Values(values...).
+		Returning(userColumns...).
+		Query()
 
-	return scanUser(u.QueryRow(ctx, q))
+	var user *types.User
+	err := u.WithTransact(ctx, func(tx *basestore.Store) (err error) {
+		user, err = scanUser(tx.QueryRow(ctx, q))
+		if err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "users", user.ID, AuditOperationCreate, nil, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Update applies update to the user in a transaction, bumping its version. If
+// nothing would change, the user is returned as it is. If the version moves on
+// while updating, a stale write error is returned, whether or not an expected
+// version was given.
+func (u *userStore) Update(ctx context.Context, userID string, update UserUpdate) (*types.User, error) {
+	ctx = withMethod(ctx, "users", "Update")
+
+	if userID == "" {
+		return nil, errors.New("no user id provided")
+	}
+
+	if update.Email != nil && *update.Email == "" {
+		return nil, errors.New("no email provided")
+	}
+
+	if update.Username != nil && *update.Username == "" {
+		return nil, errors.New("no username provided")
+	}
+
+	var updated *types.User
+	err := u.WithTransact(ctx, func(tx *basestore.Store) error {
+		old, err := UsersWith(tx).GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if update.ExpectedVersion != nil && old.Version != *update.ExpectedVersion {
+			return staleWriteErr{ID: userID, ExpectedVersion: *update.ExpectedVersion}
+		}
+
+		q := basestore.Update("users")
+		var taken []*sqlf.Query
+		if update.Email != nil && *update.Email != old.Email {
+			q = q.Set("email", *update.Email)
+			taken = append(taken, basestore.Eq("email", *update.Email))
+		}
+		if update.Username != nil && *update.Username != old.Username {
+			q = q.Set("username", *update.Username)
+			taken = append(taken, basestore.Eq("username", *update.Username))
+		}
+		if len(taken) == 0 {
+			updated = old
+			return nil
+		}
+
+		// Check for collisions up front to report which field collided. The
+		// unique indexes still catch writes racing with ours.
+		other, err := UsersWith(tx).(*userStore).get(ctx, basestore.Or(taken...), basestore.NotEq("id", userID), userLiveCond)
+		if err == nil {
+			if update.Email != nil && other.Email == *update.Email {
+				return userConflictErr{Field: "email", Value: other.Email}
+			}
+			return userConflictErr{Field: "username", Value: other.Username}
+		}
+		if err != pgx.ErrNoRows {
+			return err
+		}
+
+		query := q.SetExpr("version", sqlf.Sprintf("version + 1")).
+			Where(basestore.Eq("id", userID), basestore.Eq("version", old.Version), userLiveCond).
+			Returning(userColumns...).
+			Query()
+
+		updated, err = scanUser(tx.QueryRow(ctx, query))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				// The version moved on between our read and the update.
+				return staleWriteErr{ID: userID, ExpectedVersion: old.Version}
+			}
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "users", userID, AuditOperationUpdate, old, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (u *userStore) Delete(ctx context.Context, userID string) error {
+	ctx = withMethod(ctx, "users", "Delete")
+
+	if userID == "" {
+		return errors.New("no user id provided")
+	}
+
+	return u.WithTransact(ctx, func(tx *basestore.Store) error {
+		old, err := UsersWith(tx).GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		q := basestore.Update("users").
+			SetExpr("deleted_at", sqlf.Sprintf("now()")).
+			SetExpr("version", sqlf.Sprintf("version + 1")).
+			Where(basestore.Eq("id", userID), userLiveCond).
+			Returning(userColumns...).
+			Query()
+
+		deleted, err := scanUser(tx.QueryRow(ctx, q))
+		if err != nil {
+			return err
+		}
+
+		if err := PeopleWith(tx).DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "users", userID, AuditOperationDelete, old, deleted)
+	})
+}
+
+func (u *userStore) Restore(ctx context.Context, userID string) (*types.User, error) {
+	ctx = withMethod(ctx, "users", "Restore")
+
+	if userID == "" {
+		return nil, errors.New("no user id provided")
+	}
+
+	var restored *types.User
+	err := u.WithTransact(ctx, func(tx *basestore.Store) error {
+		old, err := UsersWith(tx).(*userStore).getByIDIncludingDeleted(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if old.DeletedAt == nil {
+			return fmt.Errorf("user with ID %s is not deleted", userID)
+		}
+
+		q := basestore.Update("users").
+			Set("deleted_at", nil).
+			SetExpr("version", sqlf.Sprintf("version + 1")).
+			Where(basestore.Eq("id", userID), basestore.IsNotNull("users.deleted_at")).
+			Returning(userColumns...).
+			Query()
+
+		restored, err = scanUser(tx.QueryRow(ctx, q))
+		if err != nil {
+			return err
+		}
+
+		if err := PeopleWith(tx).RestoreByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "users", userID, AuditOperationRestore, old, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func (u *userStore) Purge(ctx context.Context, userID string) error {
+	ctx = withMethod(ctx, "users", "Purge")
+
+	if userID == "" {
+		return errors.New("no user id provided")
+	}
+
+	return u.WithTransact(ctx, func(tx *basestore.Store) error {
+		old, err := UsersWith(tx).(*userStore).getByIDIncludingDeleted(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := PeopleWith(tx).PurgeByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		if err := tx.Exec(ctx, basestore.Delete("users").Where(basestore.Eq("id", userID)).Query()); err != nil {
+			return err
+		}
+
+		return AuditWith(tx).Record(ctx, "users", userID, AuditOperationPurge, old, nil)
+	})
 }
 
 func scanUser(sc dbutil.Scanner) (*types.User, error) {
@@ -183,6 +594,9 @@ func scanUser(sc dbutil.Scanner) (*types.User, error) {
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.Version,
+		&user.CreatedAt,
+		&user.DeletedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -190,7 +604,17 @@ func scanUser(sc dbutil.Scanner) (*types.User, error) {
 	return &user, nil
 }
 
+// IsUserNotFoundErr reports whether err is, or wraps, the error for a missing
+// user. Errors from inside a transaction come back joined with the result of
+// rolling it back, so a plain type assertion isn't enough.
 func IsUserNotFoundErr(err error) bool {
-	_, ok := err.(userNotFoundErr)
-	return ok
+	return errors.As(err, &userNotFoundErr{})
+}
+
+func IsUserConflictErr(err error) bool {
+	return errors.As(err, &userConflictErr{})
+}
+
+func IsStaleWriteErr(err error) bool {
+	return errors.As(err, &staleWriteErr{})
 }
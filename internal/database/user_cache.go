@@ -0,0 +1,342 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/keegancsmith/sqlf"
+)
+
+const (
+	defaultUserCacheSize = 1024
+	defaultUserCacheTTL  = time.Minute
+
+	// userCacheChannel is the channel invalidations are published on so that
+	// other instances can drop their copies.
+	userCacheChannel = "user_cache_invalidation"
+	// userCacheResetPayload is sent on userCacheChannel, instead of a user ID,
+	// to drop every cached user.
+	userCacheResetPayload = "*"
+)
+
+// UserCache is an in-process LRU cache of users keyed by ID and by email, where
+// every entry also expires after a fixed TTL. A user is always cached under
+// both keys or neither, so that invalidating by ID also drops the entry keyed
+// by email.
+type UserCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+
+	// generation is bumped by every invalidation. While reads are loading
+	// users from the database, invalidated records the generation at which
+	// each user was last invalidated, so that a read that started before an
+	// invalidation doesn't cache the row it loaded, which may be the old one.
+	generation  uint64
+	loading     int
+	invalidated map[string]uint64
+	// resetAt is the generation of the last reset, which invalidates everyone.
+	resetAt uint64
+
+	// notify makes invalidations also be published with NOTIFY.
+	notify bool
+	// listening is set while ListenUserCacheInvalidations is receiving
+	// invalidations from other instances.
+	listening atomic.Bool
+}
+
+type userCacheEntry struct {
+	key     string
+	user    types.User
+	expires time.Time
+}
+
+// NewUserCache returns a cache holding at most capacity entries for ttl each. When
+// notify is set, invalidations are also sent to other instances over NOTIFY; see
+// ListenUserCacheInvalidations.
+func NewUserCache(capacity int, ttl time.Duration, notify bool) *UserCache {
+	return &UserCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		ll:          list.New(),
+		items:       map[string]*list.Element{},
+		invalidated: map[string]uint64{},
+		notify:      notify,
+	}
+}
+
+func userIDCacheKey(id string) string       { return "id:" + id }
+func userEmailCacheKey(email string) string { return "email:" + email }
+
+func (c *UserCache) get(key string) (*types.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*userCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	user := entry.user
+	return &user, true
+}
+
+func (c *UserCache) set(user *types.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(user)
+}
+
+// add caches the user. c.mu must be held.
+func (c *UserCache) add(user *types.User) {
+	// Drop what we had for the user, which may be under an email it has since
+	// changed from, and for whoever had its email before.
+	for _, key := range []string{userIDCacheKey(user.ID), userEmailCacheKey(user.Email)} {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+
+	expires := time.Now().Add(c.ttl)
+	for _, key := range []string{userIDCacheKey(user.ID), userEmailCacheKey(user.Email)} {
+		c.items[key] = c.ll.PushFront(&userCacheEntry{key: key, user: *user, expires: expires})
+	}
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// startLoad is called before reading a user from the database, and returns
+// the generation to pass to finishLoad.
+func (c *UserCache) startLoad() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loading++
+	return c.generation
+}
+
+// finishLoad caches the user read since startLoad returned generation, unless
+// the user was invalidated in the meantime. user is nil if the read failed.
+func (c *UserCache) finishLoad(generation uint64, user *types.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if user != nil && c.resetAt <= generation && c.invalidated[user.ID] <= generation {
+		c.add(user)
+	}
+	c.loading--
+	if c.loading == 0 {
+		c.invalidated = map[string]uint64{}
+	}
+}
+
+// invalidate drops both entries for the user with the given ID.
+func (c *UserCache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if c.loading > 0 {
+		c.invalidated[id] = c.generation
+	}
+	if el, ok := c.items[userIDCacheKey(id)]; ok {
+		c.removeElement(el)
+	}
+}
+
+// reset drops every entry.
+func (c *UserCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.resetAt = c.generation
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+}
+
+// removeElement removes the entry along with the other one for the same user,
+// whether it is being invalidated, has expired or is being evicted.
+func (c *UserCache) removeElement(el *list.Element) {
+	entry := el.Value.(*userCacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+
+	sibling := userEmailCacheKey(entry.user.Email)
+	if entry.key == sibling {
+		sibling = userIDCacheKey(entry.user.ID)
+	}
+	if el, ok := c.items[sibling]; ok && el.Value.(*userCacheEntry).user.ID == entry.user.ID {
+		c.ll.Remove(el)
+		delete(c.items, sibling)
+	}
+}
+
+// CachedUsers wraps the store so that GetByID and GetByEmail are served from the
+// cache. Reads inside a transaction always go to the database, and writes only
+// invalidate the cache once their transaction has committed, so a transaction can
+// neither read nor publish data that other connections can't see yet.
+func CachedUsers(store UserStore, cache *UserCache) UserStore {
+	return &cachedUserStore{UserStore: store, cache: cache}
+}
+
+type cachedUserStore struct {
+	UserStore
+	cache *UserCache
+}
+
+var _ UserStore = &cachedUserStore{}
+
+func (s *cachedUserStore) GetByID(ctx context.Context, userID string) (*types.User, error) {
+	return s.getCached(userIDCacheKey(userID), func() (*types.User, error) {
+		return s.UserStore.GetByID(ctx, userID)
+	})
+}
+
+func (s *cachedUserStore) GetByEmail(ctx context.Context, email string) (*types.User, error) {
+	return s.getCached(userEmailCacheKey(email), func() (*types.User, error) {
+		return s.UserStore.GetByEmail(ctx, email)
+	})
+}
+
+func (s *cachedUserStore) getCached(key string, load func() (*types.User, error)) (*types.User, error) {
+	if s.Handle().InTransaction() {
+		return load()
+	}
+
+	if user, ok := s.cache.get(key); ok {
+		return user, nil
+	}
+
+	generation := s.cache.startLoad()
+	user, err := load()
+	if err != nil {
+		s.cache.finishLoad(generation, nil)
+		return nil, err
+	}
+	s.cache.finishLoad(generation, user)
+	return user, nil
+}
+
+func (s *cachedUserStore) Update(ctx context.Context, userID string, update UserUpdate) (*types.User, error) {
+	updated, err := s.UserStore.Update(ctx, userID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.invalidate(ctx, updated.ID); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *cachedUserStore) Delete(ctx context.Context, userID string) error {
+	if err := s.UserStore.Delete(ctx, userID); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, userID)
+}
+
+func (s *cachedUserStore) Restore(ctx context.Context, userID string) (*types.User, error) {
+	restored, err := s.UserStore.Restore(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.invalidate(ctx, userID); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+func (s *cachedUserStore) Purge(ctx context.Context, userID string) error {
+	if err := s.UserStore.Purge(ctx, userID); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, userID)
+}
+
+// invalidate drops the user from the cache once the current transaction commits,
+// and tells other instances to do the same if notifications are enabled. NOTIFY is
+// transactional, so those are also only delivered on commit.
+func (s *cachedUserStore) invalidate(ctx context.Context, userID string) error {
+	if s.cache.notify {
+		store := basestore.NewWithHandle(s.Handle())
+		if err := store.Exec(ctx, sqlf.Sprintf("SELECT pg_notify(%s, %s)", userCacheChannel, userID)); err != nil {
+			return err
+		}
+	}
+
+	handle := s.Handle()
+	invalidate := func() { s.cache.invalidate(userID) }
+	// Drop the entry right away as well, in case a concurrent read outside the
+	// transaction repopulated it with the old row.
+	if handle.InTransaction() {
+		invalidate()
+	}
+	basestore.AfterCommit(handle, invalidate)
+	return nil
+}
+
+// ResetUserCaches tells every instance listening for invalidations to empty
+// its user cache, for when users were changed without going through the
+// stores, e.g. by truncating the table. Like any other notification it is only
+// delivered once the transaction db is in, if any, commits.
+func ResetUserCaches(ctx context.Context, db DB) error {
+	_, err := db.Exec(ctx, "SELECT pg_notify($1, $2)", userCacheChannel, userCacheResetPayload)
+	return err
+}
+
+// ListenUserCacheInvalidations invalidates entries of the cache as other instances
+// publish changes, until the context is canceled. It holds on to one connection of
+// the pool for as long as it runs.
+func ListenUserCacheInvalidations(ctx context.Context, pool *pgxpool.Pool, cache *UserCache, logger *slog.Logger) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+userCacheChannel); err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "listening for user cache invalidations", "channel", userCacheChannel)
+	// Invalidations sent before we were listening, e.g. while reconnecting,
+	// are lost, so anything cached until now may be stale.
+	cache.reset()
+	cache.listening.Store(true)
+	defer cache.listening.Store(false)
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n.Payload == userCacheResetPayload {
+			cache.reset()
+			continue
+		}
+		cache.invalidate(n.Payload)
+	}
+}
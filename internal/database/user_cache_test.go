@@ -0,0 +1,223 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestUserCacheEvictsBothKeys(t *testing.T) {
+	c := NewUserCache(4, time.Minute, false)
+	alice := &types.User{ID: "a", Email: "alice@example.com"}
+	bob := &types.User{ID: "b", Email: "bob@example.com"}
+	carol := &types.User{ID: "c", Email: "carol@example.com"}
+
+	c.set(alice)
+	c.set(bob)
+	// Touch alice's email entry so that only her ID entry is least recently
+	// used when carol is added.
+	if _, ok := c.get(userEmailCacheKey(alice.Email)); !ok {
+		t.Fatal("alice not cached by email")
+	}
+	c.set(carol)
+
+	if _, ok := c.get(userIDCacheKey(alice.ID)); ok {
+		t.Fatal("expected alice's ID entry to be evicted")
+	}
+	if _, ok := c.get(userEmailCacheKey(alice.Email)); ok {
+		t.Error("alice's email entry outlived her ID entry, so invalidating her by ID would leave it stale")
+	}
+	for _, u := range []*types.User{bob, carol} {
+		if _, ok := c.get(userIDCacheKey(u.ID)); !ok {
+			t.Errorf("%s evicted", u.ID)
+		}
+	}
+}
+
+func TestUserCacheInvalidate(t *testing.T) {
+	c := NewUserCache(10, time.Minute, false)
+	alice := &types.User{ID: "a", Email: "alice@example.com"}
+	c.set(alice)
+	c.invalidate(alice.ID)
+
+	for _, key := range []string{userIDCacheKey(alice.ID), userEmailCacheKey(alice.Email)} {
+		if _, ok := c.get(key); ok {
+			t.Errorf("%s still cached", key)
+		}
+	}
+}
+
+func TestUserCacheEmailChange(t *testing.T) {
+	c := NewUserCache(10, time.Minute, false)
+	c.set(&types.User{ID: "a", Email: "old@example.com"})
+	c.set(&types.User{ID: "a", Email: "new@example.com"})
+
+	if _, ok := c.get(userEmailCacheKey("old@example.com")); ok {
+		t.Error("entry for the old email survived")
+	}
+	if user, ok := c.get(userIDCacheKey("a")); !ok || user.Email != "new@example.com" {
+		t.Errorf("got %v, want the user with the new email", user)
+	}
+
+	c.invalidate("a")
+	if _, ok := c.get(userEmailCacheKey("new@example.com")); ok {
+		t.Error("entry for the new email survived invalidation")
+	}
+}
+
+// cacheTestTx is a transaction on which every statement succeeds.
+type cacheTestTx struct {
+	pgx.Tx
+}
+
+func (cacheTestTx) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+func (cacheTestTx) Commit(context.Context) error   { return nil }
+func (cacheTestTx) Rollback(context.Context) error { return nil }
+
+// cacheTestStore holds a single user. onLoad, if set, is run once in the middle
+// of the next read, after the row has been read.
+type cacheTestStore struct {
+	UserStore
+	handle basestore.TransactableHandle
+	user   types.User
+	onLoad func()
+}
+
+func (s *cacheTestStore) Handle() basestore.TransactableHandle { return s.handle }
+
+func (s *cacheTestStore) GetByID(context.Context, string) (*types.User, error) {
+	user := s.user
+	if f := s.onLoad; f != nil {
+		s.onLoad = nil
+		f()
+	}
+	return &user, nil
+}
+
+func (s *cacheTestStore) Update(_ context.Context, _ string, update UserUpdate) (*types.User, error) {
+	if update.Email != nil {
+		s.user.Email = *update.Email
+	}
+	user := s.user
+	return &user, nil
+}
+
+func TestCachedUsersReadRacingUpdate(t *testing.T) {
+	ctx := context.Background()
+	cache := NewUserCache(10, time.Minute, false)
+	store := &cacheTestStore{
+		handle: basestore.NewHandleWithDB(testLogger, nil, pgx.TxOptions{}),
+		user:   types.User{ID: "a", Email: "old@example.com"},
+	}
+	users := CachedUsers(store, cache)
+
+	// The update commits and invalidates the user after the read got the old
+	// row, but before it was cached.
+	newEmail := "new@example.com"
+	store.onLoad = func() {
+		if _, err := users.Update(ctx, "a", UserUpdate{Email: &newEmail}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := users.GetByID(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, ok := cache.get(userIDCacheKey("a")); ok {
+		t.Errorf("cached %s, which was read before it was invalidated", user.Email)
+	}
+	user, err := users.GetByID(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != newEmail {
+		t.Errorf("got %s, want %s", user.Email, newEmail)
+	}
+	if _, ok := cache.get(userIDCacheKey("a")); !ok {
+		t.Error("a read with no invalidation in between was not cached")
+	}
+}
+
+func TestCachedUsersInvalidateAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	old := types.User{ID: "a", Email: "old@example.com"}
+	newEmail := "new@example.com"
+
+	tests := []struct {
+		name string
+		// update runs the update in tx, returning the error to finish tx with.
+		update func(t *testing.T, tx basestore.TransactableHandle, cache *UserCache) error
+		// wantCached is whether the old row, cached again while the transaction
+		// was open, survives the commit.
+		wantCached bool
+	}{
+		{
+			name: "transaction",
+			update: func(t *testing.T, tx basestore.TransactableHandle, cache *UserCache) error {
+				_, err := CachedUsers(&cacheTestStore{handle: tx, user: old}, cache).Update(ctx, "a", UserUpdate{Email: &newEmail})
+				return err
+			},
+		},
+		{
+			name: "released savepoint",
+			update: func(t *testing.T, tx basestore.TransactableHandle, cache *UserCache) error {
+				sp, err := tx.Transact(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = CachedUsers(&cacheTestStore{handle: sp, user: old}, cache).Update(ctx, "a", UserUpdate{Email: &newEmail})
+				return sp.Done(ctx, err)
+			},
+		},
+		{
+			name: "rolled back savepoint",
+			update: func(t *testing.T, tx basestore.TransactableHandle, cache *UserCache) error {
+				sp, err := tx.Transact(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := CachedUsers(&cacheTestStore{handle: sp, user: old}, cache).Update(ctx, "a", UserUpdate{Email: &newEmail}); err != nil {
+					t.Fatal(err)
+				}
+				if err := sp.Done(ctx, errors.New("rolled back")); err == nil {
+					t.Fatal("rolling back the savepoint returned no error")
+				}
+				return nil
+			},
+			wantCached: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewUserCache(10, time.Minute, false)
+			cache.set(&old)
+			tx := basestore.NewHandleWithTx(testLogger, cacheTestTx{}, pgx.TxOptions{})
+
+			if err := tt.update(t, tx, cache); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := cache.get(userIDCacheKey("a")); ok {
+				t.Fatal("the user is still cached after being updated")
+			}
+
+			// A read outside the transaction can't see the update yet, and
+			// caches the old row again.
+			cache.set(&old)
+			if err := tx.Done(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := cache.get(userIDCacheKey("a")); ok != tt.wantCached {
+				t.Errorf("got cached %t after the commit, want %t", ok, tt.wantCached)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keegancsmith/sqlf"
+)
+
+func TestListUserArgsConds(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		args      ListUserArgs
+		wantQuery string
+		wantArgs  []any
+	}{
+		{name: "none", args: ListUserArgs{}, wantQuery: `"users"."deleted_at" IS NULL`},
+		{name: "including deleted", args: ListUserArgs{IncludeDeleted: true}, wantQuery: `TRUE`},
+		{
+			name:      "ids and usernames",
+			args:      ListUserArgs{IncludeDeleted: true, IDs: []string{"a", "b"}, Usernames: []string{"ann"}},
+			wantQuery: `"users"."id" = ANY($1) AND "users"."username" = ANY($2)`,
+			wantArgs:  []any{[]string{"a", "b"}, []string{"ann"}},
+		},
+		{
+			name:      "username prefix and contains are escaped",
+			args:      ListUserArgs{IncludeDeleted: true, UsernamePrefix: "a_n", UsernameContains: "50%"},
+			wantQuery: `"users"."username" LIKE $1 AND "users"."username" ILIKE $2`,
+			wantArgs:  []any{`a\_n%`, `%50\%%`},
+		},
+		{
+			name:      "email",
+			args:      ListUserArgs{IncludeDeleted: true, Email: "Ann@Example.com", EmailDomain: "example.com", EmailContains: "ann"},
+			wantQuery: `lower(users.email) = lower($1) AND lower(split_part(users.email, '@', 2)) = lower($2) AND "users"."email" ILIKE $3`,
+			wantArgs:  []any{"Ann@Example.com", "example.com", "%ann%"},
+		},
+		{
+			name:      "created range",
+			args:      ListUserArgs{IncludeDeleted: true, CreatedSince: since, CreatedUntil: until},
+			wantQuery: `"users"."created_at" >= $1 AND "users"."created_at" < $2`,
+			wantArgs:  []any{since, until},
+		},
+		{
+			name:      "cursors",
+			args:      ListUserArgs{After: &UserCursor{Username: "ann", ID: "a"}, Before: &UserCursor{Username: "bob", ID: "b"}},
+			wantQuery: `"users"."deleted_at" IS NULL AND (users.username, users.id) > ($1, $2) AND (users.username, users.id) < ($3, $4)`,
+			wantArgs:  []any{"ann", "a", "bob", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := sqlf.Sprintf("TRUE")
+			if conds := tt.args.conds(); len(conds) > 0 {
+				q = sqlf.Join(conds, "AND")
+			}
+
+			if got := strings.Join(strings.Fields(q.Query(sqlf.PostgresBindVar)), " "); got != tt.wantQuery {
+				t.Errorf("got query %s, want %s", got, tt.wantQuery)
+			}
+			if got := q.Args(); len(got) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(got, tt.wantArgs) {
+					t.Errorf("got args %#v, want %#v", got, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
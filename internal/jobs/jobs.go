@@ -0,0 +1,87 @@
+// Package jobs runs background jobs stored in Postgres through database.JobStore.
+//
+// Job kinds are registered with a typed handler, enqueued (usually from inside the
+// transaction that makes them necessary) and picked up by a Pool of workers:
+//
+//	registry := jobs.NewRegistry()
+//	jobs.Register(registry, "user.welcome", func(ctx context.Context, p WelcomePayload) error {
+//		...
+//	})
+//
+//	err := db.WithTransact(ctx, func(tx database.DB) error {
+//		...
+//		_, err := jobs.Enqueue(ctx, tx, "user.welcome", WelcomePayload{UserID: id}, database.EnqueueJobOptions{})
+//		return err
+//	})
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+// Handler processes the payload of a job. Returning an error schedules a retry.
+type Handler[T any] func(ctx context.Context, payload T) error
+
+type handlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+// Registry maps job kinds to their handlers.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]handlerFunc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]handlerFunc{}}
+}
+
+// Register adds the handler for a kind of job, whose payloads are decoded into T.
+// Registering the same kind twice panics, as it is a programming error.
+func Register[T any](r *Registry, kind string, handler Handler[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.handlers[kind]; ok {
+		panic(fmt.Sprintf("jobs: kind %q registered twice", kind))
+	}
+
+	r.handlers[kind] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return fmt.Errorf("decoding %s payload: %w", kind, err)
+		}
+		return handler(ctx, payload)
+	}
+}
+
+// Enqueue adds a job of the given kind using the database handle, which may be a
+// transaction.
+func Enqueue[T any](ctx context.Context, db database.DB, kind string, payload T, opts database.EnqueueJobOptions) (*types.Job, error) {
+	return db.Jobs().Enqueue(ctx, kind, payload, opts)
+}
+
+func (r *Registry) kinds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func (r *Registry) handler(kind string) (handlerFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[kind]
+	return h, ok
+}
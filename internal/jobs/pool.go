@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/retry"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+const (
+	defaultConcurrency       = 4
+	defaultPollInterval      = time.Second
+	defaultVisibilityTimeout = 5 * time.Minute
+	defaultBaseBackoff       = time.Second
+	defaultMaxBackoff        = time.Hour
+)
+
+// Pool is a set of workers processing jobs of every kind in its registry.
+type Pool struct {
+	db       database.DB
+	registry *Registry
+	logger   *slog.Logger
+	workerID string
+
+	Concurrency  int
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a job stays locked to this pool without a
+	// heartbeat before other workers consider it abandoned. Heartbeats are sent
+	// at a third of it.
+	VisibilityTimeout time.Duration
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	// jobCtx is passed to handlers. It is only canceled if Stop gives up waiting
+	// for them.
+	jobCtx    context.Context
+	cancelJob context.CancelFunc
+	inflight  sync.WaitGroup
+}
+
+func NewPool(db database.DB, registry *Registry, logger *slog.Logger) *Pool {
+	hostname, _ := os.Hostname()
+	jobCtx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		db:                db,
+		registry:          registry,
+		logger:            logger,
+		workerID:          fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Concurrency:       defaultConcurrency,
+		PollInterval:      defaultPollInterval,
+		VisibilityTimeout: defaultVisibilityTimeout,
+		BaseBackoff:       defaultBaseBackoff,
+		MaxBackoff:        defaultMaxBackoff,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+		jobCtx:            jobCtx,
+		cancelJob:         cancel,
+	}
+}
+
+// Start begins polling for jobs in the background.
+func (p *Pool) Start() {
+	go p.run()
+}
+
+// Stop stops picking up new jobs and waits for running ones to finish. If ctx
+// expires first, the running jobs' contexts are canceled and ctx's error is
+// returned; those jobs are retried by whichever worker picks them up once their
+// visibility timeout passes.
+func (p *Pool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.cancelJob()
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) run() {
+	defer close(p.done)
+	defer p.inflight.Wait()
+
+	slots := make(chan struct{}, p.Concurrency)
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		free := p.Concurrency - len(slots)
+		var jobs []*types.Job
+		if free > 0 {
+			var err error
+			jobs, err = p.db.Jobs().Dequeue(p.jobCtx, p.workerID, p.registry.kinds(), free, p.VisibilityTimeout)
+			if err != nil {
+				p.logger.Error("error dequeuing jobs", "error", err)
+			}
+		}
+
+		for _, job := range jobs {
+			slots <- struct{}{}
+			p.inflight.Add(1)
+			go func(job *types.Job) {
+				defer func() {
+					<-slots
+					p.inflight.Done()
+				}()
+				p.process(job)
+			}(job)
+		}
+
+		// Go straight back for more if the batch filled every free slot.
+		if free > 0 && len(jobs) == free {
+			continue
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+func (p *Pool) process(job *types.Job) {
+	ctx, cancel := context.WithCancel(p.jobCtx)
+	defer cancel()
+
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go p.heartbeat(ctx, cancel, job, heartbeatDone)
+
+	err := p.handle(ctx, job)
+
+	// Record the outcome even if the job's context was canceled.
+	store := p.db.Jobs()
+	if err == nil {
+		err = store.Complete(context.Background(), job.ID, p.workerID)
+		if err != nil {
+			p.logger.Error("error completing job", "job_id", job.ID, "kind", job.Kind, "error", err)
+		}
+		return
+	}
+
+	p.logger.Warn("job failed", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", err)
+	if err := store.Fail(context.Background(), job.ID, p.workerID, err, time.Now().Add(retry.Backoff(p.BaseBackoff, p.MaxBackoff, int(job.Attempts)))); err != nil {
+		p.logger.Error("error failing job", "job_id", job.ID, "kind", job.Kind, "error", err)
+	}
+}
+
+func (p *Pool) handle(ctx context.Context, job *types.Job) (err error) {
+	handler, ok := p.registry.handler(job.Kind)
+	if !ok {
+		return fmt.Errorf("no handler registered for job kind %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job.Payload)
+}
+
+// heartbeat keeps the job locked while it runs. If the lock is lost to another
+// worker the job's context is canceled, since the other worker will run it again.
+func (p *Pool) heartbeat(ctx context.Context, cancel context.CancelFunc, job *types.Job, done <-chan struct{}) {
+	ticker := time.NewTicker(p.VisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := p.db.Jobs().Heartbeat(ctx, job.ID, p.workerID, p.VisibilityTimeout)
+			if errors.Is(err, database.ErrJobLockLost) {
+				p.logger.Warn("lost lock on job, canceling it", "job_id", job.ID, "kind", job.Kind)
+				cancel()
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				p.logger.Error("error sending heartbeat", "job_id", job.ID, "kind", job.Kind, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeDB serves jobs from a fakeJobStore. Calling anything else panics.
+type fakeDB struct {
+	database.DB
+	jobs *fakeJobStore
+}
+
+func (db *fakeDB) Jobs() database.JobStore { return db.jobs }
+
+// fakeJobStore hands out its queued jobs once each and records what the pool
+// does with them.
+type fakeJobStore struct {
+	database.JobStore
+
+	mu        sync.Mutex
+	queued    []*types.Job
+	completed []int64
+	failed    map[int64]time.Time
+	// heartbeatErr is returned from every heartbeat.
+	heartbeatErr error
+	heartbeats   int
+	done         chan int64
+}
+
+func newFakeJobStore(jobs ...*types.Job) *fakeJobStore {
+	return &fakeJobStore{queued: jobs, failed: map[int64]time.Time{}, done: make(chan int64, len(jobs))}
+}
+
+func (s *fakeJobStore) Dequeue(_ context.Context, _ string, _ []string, limit int, _ time.Duration) ([]*types.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := min(limit, len(s.queued))
+	jobs := s.queued[:n]
+	s.queued = s.queued[n:]
+	return jobs, nil
+}
+
+func (s *fakeJobStore) Heartbeat(context.Context, int64, string, time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heartbeats++
+	return s.heartbeatErr
+}
+
+func (s *fakeJobStore) Complete(_ context.Context, id int64, _ string) error {
+	s.mu.Lock()
+	s.completed = append(s.completed, id)
+	s.mu.Unlock()
+	s.done <- id
+	return nil
+}
+
+func (s *fakeJobStore) Fail(_ context.Context, id int64, _ string, _ error, retryAt time.Time) error {
+	s.mu.Lock()
+	s.failed[id] = retryAt
+	s.mu.Unlock()
+	s.done <- id
+	return nil
+}
+
+// wait blocks until n jobs were completed or failed.
+func (s *fakeJobStore) wait(t *testing.T, n int) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.done:
+		case <-timeout:
+			t.Fatalf("only %d of %d jobs finished", i, n)
+		}
+	}
+}
+
+type testPayload struct {
+	Outcome string `json:"outcome"`
+}
+
+func newTestJob(t *testing.T, id int64, outcome string) *types.Job {
+	t.Helper()
+
+	payload, err := json.Marshal(testPayload{Outcome: outcome})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.Job{ID: id, Kind: "test", Payload: payload, Attempts: 1}
+}
+
+func startTestPool(t *testing.T, store *fakeJobStore, visibilityTimeout time.Duration, handler Handler[testPayload]) {
+	t.Helper()
+
+	registry := NewRegistry()
+	Register(registry, "test", handler)
+
+	pool := NewPool(&fakeDB{jobs: store}, registry, testLogger)
+	pool.PollInterval = 10 * time.Millisecond
+	pool.VisibilityTimeout = visibilityTimeout
+	pool.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := pool.Stop(ctx); err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+	})
+}
+
+func TestPoolOutcomes(t *testing.T) {
+	store := newFakeJobStore(
+		newTestJob(t, 1, "ok"),
+		newTestJob(t, 2, "error"),
+		newTestJob(t, 3, "panic"),
+	)
+	start := time.Now()
+	startTestPool(t, store, time.Minute, func(ctx context.Context, p testPayload) error {
+		switch p.Outcome {
+		case "error":
+			return errors.New("boom")
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	})
+	store.wait(t, 3)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.completed) != 1 || store.completed[0] != 1 {
+		t.Errorf("got completed jobs %v, want [1]", store.completed)
+	}
+	for _, id := range []int64{2, 3} {
+		retryAt, ok := store.failed[id]
+		if !ok {
+			t.Errorf("job %d was not failed", id)
+			continue
+		}
+		if !retryAt.After(start) {
+			t.Errorf("job %d is retried at %s, want it after %s", id, retryAt, start)
+		}
+	}
+}
+
+func TestPoolHeartbeat(t *testing.T) {
+	tests := []struct {
+		name         string
+		heartbeatErr error
+		wantCanceled bool
+	}{
+		{name: "lock held"},
+		{name: "lock lost", heartbeatErr: database.ErrJobLockLost, wantCanceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeJobStore(newTestJob(t, 1, "ok"))
+			store.heartbeatErr = tt.heartbeatErr
+
+			canceled := make(chan bool, 1)
+			startTestPool(t, store, 30*time.Millisecond, func(ctx context.Context, _ testPayload) error {
+				select {
+				case <-ctx.Done():
+					canceled <- true
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+					canceled <- false
+					return nil
+				}
+			})
+			store.wait(t, 1)
+
+			if got := <-canceled; got != tt.wantCanceled {
+				t.Errorf("got canceled %t, want %t", got, tt.wantCanceled)
+			}
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			if store.heartbeats == 0 {
+				t.Error("no heartbeats were sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON documents.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// invalidPatchErr is returned when a patch is malformed, as opposed to being
+// well-formed but not applicable to the document.
+type invalidPatchErr struct {
+	Reason string
+}
+
+func (e invalidPatchErr) Error() string {
+	return "invalid patch: " + e.Reason
+}
+
+// testFailedErr is returned when a JSON Patch test operation finds a value other
+// than the one it expects.
+type testFailedErr struct {
+	Path string
+}
+
+func (e testFailedErr) Error() string {
+	return fmt.Sprintf("test failed: value at %q does not match", e.Path)
+}
+
+// pathErr is returned when an operation refers to a location the document
+// doesn't have.
+type pathErr struct {
+	Path   string
+	Reason string
+}
+
+func (e pathErr) Error() string {
+	return fmt.Sprintf("path %q: %s", e.Path, e.Reason)
+}
+
+// Merge applies an RFC 7396 merge patch to doc: objects in the patch are
+// merged into the document recursively, null removes a member and anything
+// else replaces the value it is at.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var p any
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, invalidPatchErr{Reason: err.Error()}
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+// Operation is a single step of an RFC 6902 JSON Patch.
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	// Value is nil when the operation has no value, as opposed to a JSON
+	// null.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply applies an RFC 6902 JSON Patch to doc. The operations are applied in
+// order, and if any of them fails, none of them are.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, invalidPatchErr{Reason: err.Error()}
+	}
+
+	for i, op := range ops {
+		var err error
+		if target, err = apply(target, op); err != nil {
+			if e, ok := err.(invalidPatchErr); ok {
+				e.Reason = fmt.Sprintf("operation %d: %s", i, e.Reason)
+				return nil, e
+			}
+			return nil, err
+		}
+	}
+	return json.Marshal(target)
+}
+
+func apply(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, invalidPatchErr{Reason: fmt.Sprintf("%s requires a value", op.Op)}
+		}
+		var v any
+		err := json.Unmarshal(op.Value, &v)
+		return v, err
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, path, v)
+
+	case "remove":
+		return remove(doc, op.Path, path)
+
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return update(doc, op.Path, path, func(any) (any, error) { return v, nil })
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := get(doc, op.From, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, invalidPatchErr{Reason: fmt.Sprintf("cannot move %q into itself", op.From)}
+			}
+			if doc, err = remove(doc, op.From, from); err != nil {
+				return nil, err
+			}
+		} else if v, err = deepCopy(v); err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, path, v)
+
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := get(doc, op.Path, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return nil, testFailedErr{Path: op.Path}
+		}
+		return doc, nil
+	}
+
+	return nil, invalidPatchErr{Reason: fmt.Sprintf("unknown op %q", op.Op)}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPatchErr{Reason: fmt.Sprintf("path %q must start with /", pointer)}
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(doc any, pointer string, path []string) (v any, err error) {
+	_, err = update(doc, pointer, path, func(current any) (any, error) {
+		v = current
+		return current, nil
+	})
+	return v, err
+}
+
+// update replaces the value at path, which must exist, with what f returns
+// for it.
+func update(doc any, pointer string, path []string, f func(any) (any, error)) (any, error) {
+	if len(path) == 0 {
+		return f(doc)
+	}
+
+	switch d := doc.(type) {
+	case map[string]any:
+		child, ok := d[path[0]]
+		if !ok {
+			return nil, pathErr{Path: pointer, Reason: fmt.Sprintf("no member %q", path[0])}
+		}
+		v, err := update(child, pointer, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		d[path[0]] = v
+		return d, nil
+
+	case []any:
+		i, err := index(pointer, path[0], len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := update(d[i], pointer, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = v
+		return d, nil
+	}
+	return nil, pathErr{Path: pointer, Reason: "not an object or array"}
+}
+
+// add inserts v at path, whose parent must exist.
+func add(doc any, pointer string, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	last := path[len(path)-1]
+	return update(doc, pointer, path[:len(path)-1], func(parent any) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = v
+			return p, nil
+
+		case []any:
+			if last == "-" {
+				return append(p, v), nil
+			}
+			i, err := index(pointer, last, len(p))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = v
+			return p, nil
+		}
+		return nil, pathErr{Path: pointer, Reason: "parent is not an object or array"}
+	})
+}
+
+// remove deletes the value at path, which must exist.
+func remove(doc any, pointer string, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, invalidPatchErr{Reason: "cannot remove the whole document"}
+	}
+
+	last := path[len(path)-1]
+	return update(doc, pointer, path[:len(path)-1], func(parent any) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			if _, ok := p[last]; !ok {
+				return nil, pathErr{Path: pointer, Reason: fmt.Sprintf("no member %q", last)}
+			}
+			delete(p, last)
+			return p, nil
+
+		case []any:
+			i, err := index(pointer, last, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, pathErr{Path: pointer, Reason: "parent is not an object or array"}
+	})
+}
+
+// index parses an array index token, which must be between 0 and max.
+func index(pointer, token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, pathErr{Path: pointer, Reason: fmt.Sprintf("invalid array index %q", token)}
+	}
+	if i > max {
+		return 0, pathErr{Path: pointer, Reason: fmt.Sprintf("array index %d out of range", i)}
+	}
+	return i, nil
+}
+
+func deepCopy(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c any
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// IsInvalidPatchErr reports whether err means the patch itself is malformed.
+func IsInvalidPatchErr(err error) bool {
+	return errors.As(err, &invalidPatchErr{})
+}
+
+// IsTestFailedErr reports whether err is a JSON Patch test operation failing.
+func IsTestFailedErr(err error) bool {
+	return errors.As(err, &testFailedErr{})
+}
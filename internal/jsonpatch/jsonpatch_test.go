@@ -0,0 +1,196 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// canonical re-encodes a JSON document so that documents differing only in
+// key order and whitespace compare equal.
+func canonical(t *testing.T, doc string) string {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", doc, err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "add appends with -",
+			patch: `[{"op": "add", "path": "/arr/-", "value": 4}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3, 4], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "add inserts before an index",
+			patch: `[{"op": "add", "path": "/arr/0", "value": 0}]`,
+			want:  `{"a": {"b": 1}, "arr": [0, 1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "add at the end of an array by index",
+			patch: `[{"op": "add", "path": "/arr/3", "value": 4}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3, 4], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "add null",
+			patch: `[{"op": "add", "path": "/x", "value": null}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal", "x": null}`,
+		},
+		{
+			name:  "remove from an array",
+			patch: `[{"op": "remove", "path": "/arr/1"}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "replace ~1 escape",
+			patch: `[{"op": "replace", "path": "/a~1b", "value": "x"}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "x", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "replace ~0 escape",
+			patch: `[{"op": "replace", "path": "/m~0n", "value": "x"}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "x", "~1": "literal"}`,
+		},
+		{
+			name:  "~01 is a literal ~1",
+			patch: `[{"op": "remove", "path": "/~01"}]`,
+			want:  `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde"}`,
+		},
+		{
+			name:  "move to a sibling with a common prefix",
+			patch: `[{"op": "move", "from": "/a", "path": "/ab"}]`,
+			want:  `{"ab": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "move onto itself",
+			patch: `[{"op": "move", "from": "/a", "path": "/a"}]`,
+			want:  doc,
+		},
+		{
+			name:  "copy is deep",
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "replace", "path": "/c/b", "value": 2}]`,
+			want:  `{"a": {"b": 1}, "c": {"b": 2}, "arr": [1, 2, 3], "n": 1, "null": null, "a/b": "slash", "m~n": "tilde", "~1": "literal"}`,
+		},
+		{
+			name:  "test compares numbers by value",
+			patch: `[{"op": "test", "path": "/n", "value": 1.0}]`,
+			want:  doc,
+		},
+		{
+			name:  "test null",
+			patch: `[{"op": "test", "path": "/null", "value": null}]`,
+			want:  doc,
+		},
+		{
+			name:  "test the whole document",
+			patch: `[{"op": "test", "path": "", "value": ` + doc + `}]`,
+			want:  doc,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical(t, string(got)) != canonical(t, tt.want) {
+				t.Errorf("got %s, want %s", got, canonical(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	const doc = `{"a": {"b": 1}, "arr": [1, 2, 3], "n": 1, "null": null}`
+
+	tests := []struct {
+		name        string
+		patch       string
+		invalid     bool
+		testFailure bool
+	}{
+		{name: "not a patch", patch: `{"op": "add"}`, invalid: true},
+		{name: "unknown op", patch: `[{"op": "frobnicate", "path": "/a"}]`, invalid: true},
+		{name: "missing value", patch: `[{"op": "add", "path": "/x"}]`, invalid: true},
+		{name: "relative path", patch: `[{"op": "remove", "path": "a"}]`, invalid: true},
+		{name: "move into a descendant", patch: `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`, invalid: true},
+		{name: "remove the whole document", patch: `[{"op": "remove", "path": ""}]`, invalid: true},
+		{name: "test different number", patch: `[{"op": "test", "path": "/n", "value": 2}]`, testFailure: true},
+		{name: "test number against string", patch: `[{"op": "test", "path": "/n", "value": "1"}]`, testFailure: true},
+		{name: "test null against missing", patch: `[{"op": "test", "path": "/missing", "value": null}]`},
+		{name: "test value against null", patch: `[{"op": "test", "path": "/null", "value": 0}]`, testFailure: true},
+		{name: "leading zero index", patch: `[{"op": "replace", "path": "/arr/01", "value": 0}]`},
+		{name: "leading zero insert", patch: `[{"op": "add", "path": "/arr/01", "value": 0}]`},
+		{name: "negative index", patch: `[{"op": "remove", "path": "/arr/-1"}]`},
+		{name: "- outside add", patch: `[{"op": "remove", "path": "/arr/-"}]`},
+		{name: "index past the end", patch: `[{"op": "add", "path": "/arr/4", "value": 0}]`},
+		{name: "remove missing member", patch: `[{"op": "remove", "path": "/missing"}]`},
+		{name: "add under missing parent", patch: `[{"op": "add", "path": "/missing/x", "value": 0}]`},
+		{name: "index into a number", patch: `[{"op": "replace", "path": "/n/0", "value": 0}]`},
+		{name: "later op fails", patch: `[{"op": "add", "path": "/x", "value": 0}, {"op": "test", "path": "/x", "value": 1}]`, testFailure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), []byte(tt.patch))
+			if err == nil {
+				t.Fatalf("got %s, want an error", got)
+			}
+			if IsInvalidPatchErr(err) != tt.invalid {
+				t.Errorf("IsInvalidPatchErr(%q) = %v, want %v", err, !tt.invalid, tt.invalid)
+			}
+			if IsTestFailedErr(err) != tt.testFailure {
+				t.Errorf("IsTestFailedErr(%q) = %v, want %v", err, !tt.testFailure, tt.testFailure)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "replace member", doc: `{"a": "b"}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{name: "add member", doc: `{"a": "b"}`, patch: `{"b": "c"}`, want: `{"a": "b", "b": "c"}`},
+		{name: "null removes member", doc: `{"a": "b", "b": "c"}`, patch: `{"a": null}`, want: `{"b": "c"}`},
+		{name: "null for missing member", doc: `{"a": "b"}`, patch: `{"x": null}`, want: `{"a": "b"}`},
+		{name: "arrays are replaced", doc: `{"a": [1, 2]}`, patch: `{"a": [3]}`, want: `{"a": [3]}`},
+		{name: "nested objects merge", doc: `{"a": {"b": 1, "c": 2}}`, patch: `{"a": {"b": null, "d": 3}}`, want: `{"a": {"c": 2, "d": 3}}`},
+		{name: "object replaces scalar", doc: `{"a": 1}`, patch: `{"a": {"b": null, "c": 2}}`, want: `{"a": {"c": 2}}`},
+		{name: "non-object patch replaces document", doc: `{"a": 1}`, patch: `["x"]`, want: `["x"]`},
+		{name: "empty patch", doc: `{"a": 1}`, patch: `{}`, want: `{"a": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical(t, string(got)) != canonical(t, tt.want) {
+				t.Errorf("got %s, want %s", got, canonical(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestMergeInvalid(t *testing.T) {
+	_, err := Merge([]byte(`{}`), []byte(`{`))
+	if !IsInvalidPatchErr(err) {
+		t.Errorf("got %v, want an invalid patch error", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Package logging sets up the service's slog loggers.
+//
+// Attributes that describe the work in progress, such as the request ID or the
+// store method being run, are attached to the context with WithAttrs rather
+// than to a logger, so that they reach whatever logs while handling it:
+//
+//	ctx = logging.WithAttrs(ctx, slog.String("request_id", id))
+//	...
+//	logger.InfoContext(ctx, "user created") // includes request_id
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger writing text to w at the given level, which is one of
+// debug, info, warn or error.
+func New(w io.Writer, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: l})
+	return slog.New(contextHandler{handler}), nil
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose log records carry attrs. An attribute
+// replaces any attribute with the same key already on ctx, so nested calls
+// describe the innermost operation.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	for _, a := range existing {
+		if !hasKey(attrs, a.Key) {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler adds the attributes attached to a record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
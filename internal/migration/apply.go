@@ -0,0 +1,177 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// migrationTable is where sql-migrate records applied migrations. We write to
+// it ourselves so that migrations applied here and by the sql-migrate CLI are
+// interchangeable.
+const migrationTable = "gorp_migrations"
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// apply runs a single planned migration and records it. Migrations run in a
+// transaction unless they are annotated notransaction, which statements such
+// as CREATE INDEX CONCURRENTLY require.
+func (r *Runner) apply(ctx context.Context, m *migrate.PlannedMigration, dir migrate.MigrationDirection) error {
+	if m.DisableTransaction {
+		return r.applyWithoutTransaction(ctx, m, dir)
+	}
+	return r.applyInTransaction(ctx, m, dir)
+}
+
+// applyInTransaction runs the whole migration in a transaction, retrying it
+// from the start if it fails to get a lock.
+func (r *Runner) applyInTransaction(ctx context.Context, m *migrate.PlannedMigration, dir migrate.MigrationDirection) error {
+	return r.retry(ctx, m.Id, func() error {
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('lock_timeout', $1, true)", r.lockTimeoutSetting()); err != nil {
+			return err
+		}
+		for _, q := range m.Queries {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+		if err := record(ctx, tx, m.Id, dir); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// applyWithoutTransaction runs the statements of the migration one at a time,
+// retrying each one on its own if it fails to get a lock. The statements
+// already run are not rolled back if a later one fails for good, so they must
+// be safe to run again, e.g. by using IF NOT EXISTS.
+func (r *Runner) applyWithoutTransaction(ctx context.Context, m *migrate.PlannedMigration, dir migrate.MigrationDirection) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('lock_timeout', $1, false)", r.lockTimeoutSetting()); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "RESET lock_timeout"); err != nil {
+			r.logger.Error("error resetting lock_timeout", "error", err)
+		}
+	}()
+
+	for _, q := range m.Queries {
+		err := r.retry(ctx, m.Id, func() error {
+			if err := r.dropInvalidIndex(ctx, conn, q); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(ctx, q)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return record(ctx, conn, m.Id, dir)
+}
+
+func record(ctx context.Context, db execer, id string, dir migrate.MigrationDirection) error {
+	var err error
+	if dir == migrate.Up {
+		_, err = db.ExecContext(ctx, "INSERT INTO "+migrationTable+" (id, applied_at) VALUES ($1, $2)", id, time.Now())
+	} else {
+		_, err = db.ExecContext(ctx, "DELETE FROM "+migrationTable+" WHERE id = $1", id)
+	}
+	return err
+}
+
+func (r *Runner) lockTimeoutSetting() string {
+	return fmt.Sprintf("%dms", r.StatementLockTimeout.Milliseconds())
+}
+
+// retry calls f until it succeeds, fails with an error other than failing to
+// get a lock, or has been retried r.Retries times.
+func (r *Runner) retry(ctx context.Context, id string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt > r.Retries || !isLockError(err) {
+			return err
+		}
+
+		delay := time.Duration(attempt) * time.Second
+		r.logger.WarnContext(ctx, "migration failed to get a lock, retrying", "migration", id, "error", err, "delay", delay, "attempt", attempt, "retries", r.Retries)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isLockError reports whether err means a statement gave up waiting for a lock,
+// or was chosen as a deadlock victim, and so may succeed if run again.
+func isLockError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case "55P03", // lock_not_available
+		"40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
+
+var createIndexConcurrentlyRe = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY\s+(?:IF\s+NOT\s+EXISTS\s+)?("[^"]+"|\w+)`)
+
+// dropInvalidIndex drops the index q creates if it already exists but is
+// INVALID. A CREATE INDEX CONCURRENTLY that fails leaves such an index behind:
+// it costs writes but is never used for reads, and it makes a retry with IF NOT
+// EXISTS succeed without building anything.
+func (r *Runner) dropInvalidIndex(ctx context.Context, db *sql.Conn, q string) error {
+	match := createIndexConcurrentlyRe.FindStringSubmatch(q)
+	if match == nil {
+		return nil
+	}
+	name := match[1]
+	if strings.HasPrefix(name, `"`) {
+		name = strings.Trim(name, `"`)
+	} else {
+		name = strings.ToLower(name)
+	}
+
+	var invalid bool
+	err := db.QueryRowContext(ctx, `
+SELECT EXISTS (
+	SELECT 1
+	FROM pg_index i
+	JOIN pg_class c ON c.oid = i.indexrelid
+	WHERE c.relname = $1 AND pg_table_is_visible(c.oid) AND NOT i.indisvalid
+)`, name).Scan(&invalid)
+	if err != nil || !invalid {
+		return err
+	}
+
+	r.logger.WarnContext(ctx, "dropping invalid index left by an earlier failed build", "index", name)
+	_, err = db.ExecContext(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+pgx.Identifier{name}.Sanitize())
+	return err
+}
@@ -0,0 +1,313 @@
+// Package migration applies, rolls back and reports on the embedded schema
+// migrations.
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/migrations"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const dialect = "postgres"
+
+const (
+	defaultLockTimeout          = 5 * time.Minute
+	defaultStatementLockTimeout = 5 * time.Second
+	defaultRetries              = 5
+)
+
+// Runner runs migrations from a source against a database. Up, Down and Redo
+// hold a Postgres advisory lock while they run, so instances starting at the
+// same time apply each migration once: the first takes the lock and the rest
+// wait for it, then find nothing left to do.
+type Runner struct {
+	db       *sql.DB
+	source   migrate.MigrationSource
+	logger   *slog.Logger
+	instance string
+
+	// LockTimeout is how long to wait for another instance to finish migrating
+	// before giving up.
+	LockTimeout time.Duration
+	// StatementLockTimeout is the lock_timeout every migration runs with, so a
+	// migration waiting on a table lock gives up instead of queueing all other
+	// traffic on that table behind it.
+	StatementLockTimeout time.Duration
+	// Retries is how many times a migration, or a statement of a notransaction
+	// migration, is retried after failing to get a lock.
+	Retries int
+}
+
+// NewRunner returns a Runner for the migrations embedded in the binary.
+func NewRunner(db *sql.DB, logger *slog.Logger) *Runner {
+	hostname, _ := os.Hostname()
+	return &Runner{
+		db:          db,
+		source:      migrations.Source(),
+		logger:      logger,
+		instance:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		LockTimeout: defaultLockTimeout,
+
+		StatementLockTimeout: defaultStatementLockTimeout,
+		Retries:              defaultRetries,
+	}
+}
+
+// Up applies up to max pending migrations, or all of them if max is 0, and
+// returns how many were applied.
+func (r *Runner) Up(ctx context.Context, max int) (n int, err error) {
+	err = r.withLock(ctx, func() error {
+		n, err = r.exec(ctx, migrate.Up, max)
+		return err
+	})
+	return n, err
+}
+
+// Down rolls back up to max applied migrations, most recent first, and returns
+// how many were rolled back. max must be at least 1: rolling back everything
+// is DownAll, so that it can't be asked for by accident.
+func (r *Runner) Down(ctx context.Context, max int) (n int, err error) {
+	if max < 1 {
+		return 0, fmt.Errorf("cannot roll back %d migrations", max)
+	}
+	err = r.withLock(ctx, func() error {
+		n, err = r.exec(ctx, migrate.Down, max)
+		return err
+	})
+	return n, err
+}
+
+// DownAll rolls back every applied migration, most recent first, and returns
+// how many were rolled back.
+func (r *Runner) DownAll(ctx context.Context) (n int, err error) {
+	err = r.withLock(ctx, func() error {
+		n, err = r.exec(ctx, migrate.Down, 0)
+		return err
+	})
+	return n, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again.
+func (r *Runner) Redo(ctx context.Context) error {
+	return r.withLock(ctx, func() error {
+		planned, err := r.Plan(migrate.Down, 1)
+		if err != nil {
+			return err
+		}
+		if len(planned) == 0 {
+			return errors.New("no migrations have been applied")
+		}
+
+		if _, err := r.exec(ctx, migrate.Down, 1); err != nil {
+			return err
+		}
+		_, err = r.exec(ctx, migrate.Up, 1)
+		return err
+	})
+}
+
+// exec must be called with the lock held. It plans again rather than trusting
+// anything planned before the lock was taken, since another instance may have
+// migrated in the meantime.
+func (r *Runner) exec(ctx context.Context, dir migrate.MigrationDirection, max int) (int, error) {
+	planned, err := r.Plan(dir, max)
+	if err != nil {
+		return 0, err
+	}
+	if len(planned) == 0 {
+		r.logger.InfoContext(ctx, "no migrations to run", "instance", r.instance, "direction", direction(dir))
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(planned))
+	for _, m := range planned {
+		ids = append(ids, m.Id)
+	}
+	r.logger.InfoContext(ctx, "running migrations", "instance", r.instance, "direction", direction(dir), "migrations", strings.Join(ids, ", "))
+
+	for i, m := range planned {
+		if err := r.apply(ctx, m, dir); err != nil {
+			return i, fmt.Errorf("migration %s: %w", m.Id, err)
+		}
+		r.logger.InfoContext(ctx, "ran migration", "instance", r.instance, "direction", direction(dir), "migration", m.Id)
+	}
+	return len(planned), nil
+}
+
+// lockKey identifies the migration advisory lock. It is arbitrary but must
+// never change, or old and new instances would stop excluding each other.
+const lockKey int64 = 0x6d69677261746521
+
+const lockPollInterval = time.Second
+
+// withLock runs f while holding the migration lock. The lock is session level,
+// so it is taken on a connection of its own that is kept until f returns.
+func (r *Runner) withLock(ctx context.Context, f func() error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, r.LockTimeout)
+	defer cancel()
+
+	for waited := false; ; waited = true {
+		var locked bool
+		if err := conn.QueryRowContext(lockCtx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil {
+			if lockCtx.Err() != nil && ctx.Err() == nil {
+				return r.lockTimeoutErr()
+			}
+			return err
+		}
+		if locked {
+			if waited {
+				r.logger.InfoContext(ctx, "acquired the migration lock", "instance", r.instance)
+			}
+			break
+		}
+		if !waited {
+			r.logger.InfoContext(ctx, "waiting for another instance to finish migrating", "instance", r.instance)
+		}
+
+		select {
+		case <-lockCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return r.lockTimeoutErr()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+			r.logger.Error("error releasing the migration lock", "instance", r.instance, "error", err)
+		}
+	}()
+	return f()
+}
+
+func (r *Runner) lockTimeoutErr() error {
+	return fmt.Errorf("timed out after %s waiting for another instance to finish migrating", r.LockTimeout)
+}
+
+func direction(dir migrate.MigrationDirection) string {
+	if dir == migrate.Down {
+		return "down"
+	}
+	return "up"
+}
+
+// Plan returns the migrations Up or Down would run, with the statements each
+// would execute, without running them.
+func (r *Runner) Plan(dir migrate.MigrationDirection, max int) ([]*migrate.PlannedMigration, error) {
+	planned, _, err := migrate.PlanMigration(r.db, dialect, r.source, dir, max)
+	return planned, err
+}
+
+// Status is whether a single migration has been applied.
+type Status struct {
+	ID string
+	// AppliedAt is nil if the migration is pending.
+	AppliedAt *time.Time
+}
+
+// Status lists every known migration in order, along with any migrations
+// recorded in the database that are missing from the source.
+func (r *Runner) Status() ([]Status, error) {
+	found, err := r.source.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	records, err := migrate.GetMigrationRecords(r.db, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		applied[record.Id] = record.AppliedAt
+	}
+
+	statuses := make([]Status, 0, len(found))
+	for _, m := range found {
+		status := Status{ID: m.Id}
+		if at, ok := applied[m.Id]; ok {
+			status.AppliedAt = &at
+			delete(applied, m.Id)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range records {
+		if _, ok := applied[record.Id]; ok {
+			at := record.AppliedAt
+			statuses = append(statuses, Status{ID: record.Id + " (unknown)", AppliedAt: &at})
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the IDs of the migrations that have not been applied yet.
+func (r *Runner) Pending() ([]string, error) {
+	planned, err := r.Plan(migrate.Up, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(planned))
+	for _, m := range planned {
+		ids = append(ids, m.Id)
+	}
+	return ids, nil
+}
+
+// Current returns the ID of the latest applied migration, or "" if none have
+// been applied.
+func (r *Runner) Current() (string, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return "", err
+	}
+
+	current := ""
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			current = status.ID
+		}
+	}
+	return current, nil
+}
+
+var migrationNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create writes an empty migration named after the current time and name into
+// dir and returns its path. Timestamps sort after the original numbered
+// migrations, so new files always run last.
+func Create(dir, name string, now time.Time) (string, error) {
+	if !migrationNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.sql", now.UTC().Format("20060102150405"), name))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString("-- +migrate Up\n\n-- +migrate Down\n"); err != nil {
+		return "", err
+	}
+	return path, nil
+}
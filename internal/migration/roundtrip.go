@@ -0,0 +1,129 @@
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// RoundTrip applies every migration against db, which must be an empty scratch
+// database, checking that each one can be rolled back and applied again:
+// after each Down the catalog must be exactly what it was before the Up, and
+// applying the Up again must produce exactly what it did the first time.
+// Finally everything is rolled back and applied once more as a whole.
+//
+// It is meant for tests and CI as much as for the migrate roundtrip command:
+//
+//	err := migration.WithScratchDatabase(ctx, admin, config, func(db *sql.DB) error {
+//		return migration.NewRunner(db, logger).RoundTrip(ctx)
+//	})
+func (r *Runner) RoundTrip(ctx context.Context) error {
+	pending, err := r.Pending()
+	if err != nil {
+		return err
+	}
+
+	initial, err := TakeSnapshot(ctx, r.db)
+	if err != nil {
+		return err
+	}
+
+	before := initial
+	for _, id := range pending {
+		if _, err := r.Up(ctx, 1); err != nil {
+			return err
+		}
+		afterUp, err := TakeSnapshot(ctx, r.db)
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.Down(ctx, 1); err != nil {
+			return fmt.Errorf("rolling back %s: %w", id, err)
+		}
+		if err := r.compare(ctx, before, "Down of %s does not reverse its Up", id); err != nil {
+			return err
+		}
+
+		if _, err := r.Up(ctx, 1); err != nil {
+			return fmt.Errorf("reapplying %s: %w", id, err)
+		}
+		if err := r.compare(ctx, afterUp, "Up of %s does not produce the same schema after its Down", id); err != nil {
+			return err
+		}
+		before = afterUp
+	}
+
+	final := before
+	if _, err := r.DownAll(ctx); err != nil {
+		return fmt.Errorf("rolling back every migration: %w", err)
+	}
+	if err := r.compare(ctx, initial, "rolling back every migration does not restore the empty schema"); err != nil {
+		return err
+	}
+	if _, err := r.Up(ctx, 0); err != nil {
+		return fmt.Errorf("reapplying every migration: %w", err)
+	}
+	return r.compare(ctx, final, "reapplying every migration does not produce the same schema")
+}
+
+func (r *Runner) compare(ctx context.Context, want Snapshot, format string, args ...any) error {
+	have, err := TakeSnapshot(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	if diff := have.Diff(want); len(diff) > 0 {
+		return fmt.Errorf("%s:\n\t%s", fmt.Sprintf(format, args...), strings.Join(diff, "\n\t"))
+	}
+	return nil
+}
+
+// MigratedSnapshot returns the catalog every migration produces when applied
+// to an empty scratch database.
+func MigratedSnapshot(ctx context.Context, admin *sql.DB, config *pgx.ConnConfig, r *Runner) (s Snapshot, err error) {
+	err = WithScratchDatabase(ctx, admin, config, func(db *sql.DB) error {
+		scratch := *r
+		scratch.db = db
+		if _, err := scratch.Up(ctx, 0); err != nil {
+			return err
+		}
+		s, err = TakeSnapshot(ctx, db)
+		return err
+	})
+	return s, err
+}
+
+// WithScratchDatabase creates an empty database on the server admin is
+// connected to, calls f with a connection to it, and drops it again. config is
+// used to connect to the new database, with its name swapped in.
+func WithScratchDatabase(ctx context.Context, admin *sql.DB, config *pgx.ConnConfig, f func(db *sql.DB) error) (err error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := "scratch_" + hex.EncodeToString(suffix)
+	ident := pgx.Identifier{name}.Sanitize()
+
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+ident); err != nil {
+		return fmt.Errorf("creating scratch database: %w", err)
+	}
+	defer func() {
+		_, dropErr := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+ident+" WITH (FORCE)")
+		if dropErr != nil && err == nil {
+			err = fmt.Errorf("dropping scratch database %s: %w", name, dropErr)
+		}
+	}()
+
+	scratchConfig := config.Copy()
+	scratchConfig.Database = name
+	db := stdlib.OpenDB(*scratchConfig)
+	defer db.Close()
+
+	return f(db)
+}
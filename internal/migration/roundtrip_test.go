@@ -0,0 +1,33 @@
+package migration_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbtest"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// TestMigrationsRoundTrip checks that every migration rolls back and
+// reapplies cleanly, in a scratch database on the test server, so it needs a
+// user that can create databases. It is in its own package since dbtest
+// imports migration.
+func TestMigrationsRoundTrip(t *testing.T) {
+	config, err := pgx.ParseConfig(dbtest.DSN(t))
+	if err != nil {
+		t.Fatalf("invalid %s: %v", dbtest.DSNEnv, err)
+	}
+	admin := stdlib.OpenDB(*config)
+	defer admin.Close()
+
+	ctx := context.Background()
+	err = migration.WithScratchDatabase(ctx, admin, config, func(db *sql.DB) error {
+		return migration.NewRunner(db, dbtest.Logger).RoundTrip(ctx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
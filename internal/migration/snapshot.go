@@ -0,0 +1,95 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Snapshot is the part of a database's catalog that migrations change, with
+// each object rendered as a line of text so that two snapshots can be diffed.
+// The table sql-migrate records applied migrations in is left out.
+type Snapshot map[string][]string
+
+var snapshotQueries = []struct {
+	kind  string
+	query string
+}{
+	{"extension", `
+SELECT extname || ' ' || extversion
+FROM pg_extension`},
+	{"table", `
+SELECT table_name
+FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' AND table_name <> '` + migrationTable + `'`},
+	{"column", `
+SELECT table_name || '.' || column_name || ' ' || data_type
+	|| CASE WHEN is_nullable = 'NO' THEN ' NOT NULL' ELSE '' END
+	|| COALESCE(' DEFAULT ' || column_default, '')
+FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name <> '` + migrationTable + `'`},
+	{"index", `
+SELECT indexdef
+FROM pg_indexes
+WHERE schemaname = current_schema() AND tablename <> '` + migrationTable + `'`},
+	{"constraint", `
+SELECT conrelid::regclass::text || ' ' || conname || ' ' || pg_get_constraintdef(oid)
+FROM pg_constraint
+WHERE connamespace = current_schema()::regnamespace AND conrelid::regclass::text <> '` + migrationTable + `'`},
+}
+
+// TakeSnapshot records the catalog of the database db is connected to.
+func TakeSnapshot(ctx context.Context, db *sql.DB) (Snapshot, error) {
+	s := Snapshot{}
+	for _, q := range snapshotQueries {
+		rows, err := db.QueryContext(ctx, q.query)
+		if err != nil {
+			return nil, fmt.Errorf("snapshotting %ss: %w", q.kind, err)
+		}
+
+		var objects []string
+		for rows.Next() {
+			var object string
+			if err := rows.Scan(&object); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		sort.Strings(objects)
+		s[q.kind] = objects
+	}
+	return s, nil
+}
+
+// Diff lists the objects in want missing from s, prefixed with "-", and the
+// objects in s missing from want, prefixed with "+". It is empty if the two
+// snapshots are the same.
+func (s Snapshot) Diff(want Snapshot) []string {
+	var diff []string
+	for _, q := range snapshotQueries {
+		have := make(map[string]bool, len(s[q.kind]))
+		for _, object := range s[q.kind] {
+			have[object] = true
+		}
+		for _, object := range want[q.kind] {
+			if have[object] {
+				delete(have, object)
+				continue
+			}
+			diff = append(diff, fmt.Sprintf("- %s %s", q.kind, object))
+		}
+		for _, object := range s[q.kind] {
+			if have[object] {
+				diff = append(diff, fmt.Sprintf("+ %s %s", q.kind, object))
+			}
+		}
+	}
+	return diff
+}
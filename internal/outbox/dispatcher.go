@@ -0,0 +1,133 @@
+// Package outbox delivers the messages stores enqueue with database.OutboxStore.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/retry"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+const (
+	defaultBatchSize    = 20
+	defaultPollInterval = time.Second
+	defaultMaxAttempts  = 10
+	defaultBaseBackoff  = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+)
+
+// Publisher delivers a message to wherever events go. Publish may be called more
+// than once for the same message, e.g. if the process dies after publishing but
+// before the delivery is recorded, so consumers should deduplicate on the ID.
+type Publisher interface {
+	Publish(ctx context.Context, msg *types.OutboxMessage) error
+}
+
+// LogPublisher is a Publisher that only logs messages, for local development.
+type LogPublisher struct {
+	Logger *slog.Logger
+}
+
+func (p LogPublisher) Publish(_ context.Context, msg *types.OutboxMessage) error {
+	p.Logger.Info("outbox message", "id", msg.ID, "topic", msg.Topic, "payload", string(msg.Payload))
+	return nil
+}
+
+// Dispatcher polls the outbox and hands pending messages to a Publisher. Several
+// dispatchers, in one or many processes, can run against the same database since
+// claimed messages are skipped by the others.
+type Dispatcher struct {
+	db        database.DB
+	publisher Publisher
+	logger    *slog.Logger
+
+	BatchSize    int
+	PollInterval time.Duration
+	// MaxAttempts is the number of failed deliveries after which a message is
+	// moved to the dead state.
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewDispatcher(db database.DB, publisher Publisher, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		publisher:    publisher,
+		logger:       logger,
+		BatchSize:    defaultBatchSize,
+		PollInterval: defaultPollInterval,
+		MaxAttempts:  defaultMaxAttempts,
+		BaseBackoff:  defaultBaseBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+	}
+}
+
+// Run dispatches messages until the context is canceled. Full batches are
+// followed immediately by the next one; otherwise it waits for PollInterval.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.dispatchBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.ErrorContext(ctx, "error dispatching outbox messages", "error", err)
+		}
+
+		if n == d.BatchSize && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// dispatchBatch claims and publishes one batch of messages, returning how many
+// were claimed. Delivery failures are recorded on the messages rather than
+// returned, so one bad message doesn't hold back the rest of the batch.
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	var n int
+	err := d.db.WithTransact(ctx, func(tx database.DB) error {
+		outbox := tx.Outbox()
+		messages, err := outbox.Claim(ctx, d.BatchSize)
+		if err != nil {
+			return err
+		}
+		n = len(messages)
+
+		for _, msg := range messages {
+			pubErr := d.publish(ctx, msg)
+			switch {
+			case pubErr == nil:
+				err = outbox.MarkDelivered(ctx, msg.ID)
+			case int(msg.Attempts)+1 >= d.MaxAttempts:
+				d.logger.WarnContext(ctx, "outbox message failed too many times, giving up", "id", msg.ID, "topic", msg.Topic, "attempts", msg.Attempts+1, "error", pubErr)
+				err = outbox.MarkDead(ctx, msg.ID, pubErr)
+			default:
+				err = outbox.MarkFailed(ctx, msg.ID, pubErr, time.Now().Add(retry.Backoff(d.BaseBackoff, d.MaxBackoff, int(msg.Attempts))))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// publish calls the publisher, turning a panic into an error so that a single
+// message can't take the dispatcher down.
+func (d *Dispatcher) publish(ctx context.Context, msg *types.OutboxMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("publisher panicked: %v", r)
+		}
+	}()
+	return d.publisher.Publish(ctx, msg)
+}
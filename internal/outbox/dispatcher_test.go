@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeDB serves messages from a fakeOutboxStore. Calling anything else panics.
+type fakeDB struct {
+	database.DB
+	outbox *fakeOutboxStore
+}
+
+func (db *fakeDB) Outbox() database.OutboxStore { return db.outbox }
+
+func (db *fakeDB) WithTransact(_ context.Context, f func(tx database.DB) error) error {
+	return f(db)
+}
+
+// fakeOutboxStore hands out its pending messages once each and records what
+// the dispatcher does with them.
+type fakeOutboxStore struct {
+	database.OutboxStore
+
+	pending   []*types.OutboxMessage
+	delivered []int64
+	failed    map[int64]time.Time
+	dead      []int64
+}
+
+func (s *fakeOutboxStore) Claim(_ context.Context, limit int) ([]*types.OutboxMessage, error) {
+	n := min(limit, len(s.pending))
+	messages := s.pending[:n]
+	s.pending = s.pending[n:]
+	return messages, nil
+}
+
+func (s *fakeOutboxStore) MarkDelivered(_ context.Context, id int64) error {
+	s.delivered = append(s.delivered, id)
+	return nil
+}
+
+func (s *fakeOutboxStore) MarkFailed(_ context.Context, id int64, _ error, retryAt time.Time) error {
+	s.failed[id] = retryAt
+	return nil
+}
+
+func (s *fakeOutboxStore) MarkDead(_ context.Context, id int64, _ error) error {
+	s.dead = append(s.dead, id)
+	return nil
+}
+
+// fakePublisher calls publish with every message it is given.
+type fakePublisher struct {
+	published []int64
+	publish   func(msg *types.OutboxMessage) error
+}
+
+func (p *fakePublisher) Publish(_ context.Context, msg *types.OutboxMessage) error {
+	p.published = append(p.published, msg.ID)
+	return p.publish(msg)
+}
+
+func newTestDispatcher(publish func(msg *types.OutboxMessage) error, messages ...*types.OutboxMessage) (*Dispatcher, *fakeOutboxStore, *fakePublisher) {
+	store := &fakeOutboxStore{pending: messages, failed: map[int64]time.Time{}}
+	publisher := &fakePublisher{publish: publish}
+	d := NewDispatcher(&fakeDB{outbox: store}, publisher, testLogger)
+	d.MaxAttempts = 3
+	d.BaseBackoff = time.Minute
+	d.MaxBackoff = time.Hour
+	return d, store, publisher
+}
+
+func TestDispatchBatch(t *testing.T) {
+	errBoom := errors.New("boom")
+	failOdd := func(msg *types.OutboxMessage) error {
+		if msg.ID%2 == 1 {
+			return errBoom
+		}
+		return nil
+	}
+
+	t.Run("outcomes", func(t *testing.T) {
+		d, store, publisher := newTestDispatcher(failOdd,
+			&types.OutboxMessage{ID: 1, Attempts: 0},
+			&types.OutboxMessage{ID: 2, Attempts: 0},
+			&types.OutboxMessage{ID: 3, Attempts: 1},
+			&types.OutboxMessage{ID: 5, Attempts: 2},
+		)
+
+		start := time.Now()
+		n, err := d.dispatchBatch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 || len(publisher.published) != 4 {
+			t.Fatalf("claimed %d and published %v, want all 4 messages", n, publisher.published)
+		}
+
+		if len(store.delivered) != 1 || store.delivered[0] != 2 {
+			t.Errorf("got %v delivered, want [2]", store.delivered)
+		}
+		// Backoff takes off up to a fifth of the delay as jitter.
+		for id, delay := range map[int64]time.Duration{1: time.Minute, 3: 2 * time.Minute} {
+			retryAt, ok := store.failed[id]
+			if !ok {
+				t.Errorf("message %d wasn't scheduled for a retry", id)
+				continue
+			}
+			if earliest, latest := start.Add(delay*4/5), time.Now().Add(delay); retryAt.Before(earliest) || retryAt.After(latest) {
+				t.Errorf("message %d is retried at %s, want between %s and %s", id, retryAt, earliest, latest)
+			}
+		}
+		if len(store.failed) != 2 {
+			t.Errorf("got %v failed, want messages 1 and 3", store.failed)
+		}
+		if len(store.dead) != 1 || store.dead[0] != 5 {
+			t.Errorf("got %v dead, want message 5, which was on its last attempt", store.dead)
+		}
+	})
+
+	t.Run("publisher panics", func(t *testing.T) {
+		d, store, _ := newTestDispatcher(func(*types.OutboxMessage) error { panic("boom") },
+			&types.OutboxMessage{ID: 1},
+		)
+		if _, err := d.dispatchBatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.failed[1]; !ok {
+			t.Errorf("got %v failed, want the panic recorded as a failed delivery", store.failed)
+		}
+	})
+
+	t.Run("batch size", func(t *testing.T) {
+		d, store, _ := newTestDispatcher(failOdd,
+			&types.OutboxMessage{ID: 2},
+			&types.OutboxMessage{ID: 4},
+			&types.OutboxMessage{ID: 6},
+		)
+		d.BatchSize = 2
+
+		for _, want := range []int{2, 1, 0} {
+			n, err := d.dispatchBatch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != want {
+				t.Errorf("claimed %d, want %d", n, want)
+			}
+		}
+		if len(store.delivered) != 3 {
+			t.Errorf("got %v delivered, want all 3 messages", store.delivered)
+		}
+	})
+}
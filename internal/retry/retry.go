@@ -0,0 +1,22 @@
+// Package retry computes delays between attempts at something that keeps
+// failing.
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the delay before the next attempt after the given number of
+// earlier failures: base doubled for each of them, capped at max, less up to
+// 20% jitter so that attempts that failed together don't retry together. The
+// jitter is taken off rather than added so that the delay never exceeds max.
+func Backoff(base, max time.Duration, attempts int) time.Duration {
+	delay := max
+	if attempts < 30 {
+		if exp := base << attempts; exp > 0 && exp < max {
+			delay = exp
+		}
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
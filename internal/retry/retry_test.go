@@ -0,0 +1,29 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	const base, max = time.Second, time.Minute
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: time.Second},
+		{attempts: 1, want: 2 * time.Second},
+		{attempts: 5, want: 32 * time.Second},
+		{attempts: 6, want: time.Minute},
+		{attempts: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := Backoff(base, max, tt.attempts)
+			if got > tt.want || got < tt.want-tt.want/5 {
+				t.Fatalf("Backoff(%s, %s, %d) = %s, want between %s and %s", base, max, tt.attempts, got, tt.want-tt.want/5, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package seed loads fixtures into the database through the stores, so that
+// validation and auditing run just as they do for API requests.
+//
+// Fixtures are JSON, or YAML in files named .yaml or .yml. Every fixture has a name, unique within its kind, that
+// other fixtures refer to it by and that its ID is derived from, so loading the
+// same fixtures into an empty database always produces the same IDs:
+//
+//	{
+//		"users": [
+//			{"name": "ada", "username": "ada", "email": "ada@example.com"}
+//		],
+//		"people": [
+//			{"name": "ada", "user_id": "ada"}
+//		]
+//	}
+//
+// or, in YAML:
+//
+//	users:
+//	  - name: ada
+//	    username: ada
+//	    email: ada@example.com
+//	people:
+//	  - name: ada
+//	    user_id: ada
+//
+// Fixtures can only refer to fixtures loaded with them, so Merge files that
+// refer to each other's fixtures and load them together.
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// namespace is the UUID namespace fixture IDs are derived in. It must never
+// change, or fixture IDs baked into tests would change with it.
+var namespace = uuid.MustParse("0b6f1c0e-8a4e-4a47-9d43-6f1e0f3c2a91")
+
+type Fixtures struct {
+	Users  []UserFixture   `json:"users" yaml:"users"`
+	People []PersonFixture `json:"people" yaml:"people"`
+}
+
+type UserFixture struct {
+	Name     string `json:"name" yaml:"name"`
+	Username string `json:"username" yaml:"username"`
+	Email    string `json:"email" yaml:"email"`
+}
+
+type PersonFixture struct {
+	Name string `json:"name" yaml:"name"`
+	// UserID is the name of a user fixture, or the ID of a user that already
+	// exists.
+	UserID string `json:"user_id" yaml:"user_id"`
+}
+
+// Result holds the records created for each fixture, by fixture name.
+type Result struct {
+	Users  map[string]*types.User
+	People map[string]*types.People
+}
+
+// UserID returns the ID the user fixture with the given name is created with.
+func UserID(name string) string {
+	return uuid.NewSHA1(namespace, []byte("users/"+name)).String()
+}
+
+// Read decodes fixtures, rejecting unknown fields so that typos don't silently
+// produce incomplete data.
+func Read(r io.Reader) (*Fixtures, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var f Fixtures
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ReadYAML decodes fixtures written in YAML, rejecting unknown fields just as
+// Read does.
+func ReadYAML(r io.Reader) (*Fixtures, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var f Fixtures
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ReadFile decodes the fixtures in the named file, as YAML if its extension is
+// .yaml or .yml and as JSON otherwise.
+func ReadFile(path string) (*Fixtures, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	read := Read
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		read = ReadYAML
+	}
+	f, err := read(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixtures from %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Merge combines the fixtures of several files, so that they can refer to
+// each other's fixtures by name. Problems found when loading them are reported
+// by position among the merged fixtures.
+func Merge(fs ...*Fixtures) *Fixtures {
+	var merged Fixtures
+	for _, f := range fs {
+		merged.Users = append(merged.Users, f.Users...)
+		merged.People = append(merged.People, f.People...)
+	}
+	return &merged
+}
+
+// Load creates every fixture in a single transaction: either all of them are
+// loaded or none are. Fixtures whose records already exist fail to load, so
+// use Reset first to load fixtures again.
+func Load(ctx context.Context, db database.DB, f *Fixtures) (*Result, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+
+	var result *Result
+	err := db.WithTransact(ctx, func(tx database.DB) error {
+		result = &Result{
+			Users:  make(map[string]*types.User, len(f.Users)),
+			People: make(map[string]*types.People, len(f.People)),
+		}
+
+		for _, u := range f.Users {
+			user, err := tx.Users().CreateWithID(ctx, UserID(u.Name), u.Email, u.Username)
+			if err != nil {
+				return fmt.Errorf("user %q: %w", u.Name, err)
+			}
+			result.Users[u.Name] = user
+		}
+
+		for _, p := range f.People {
+			person, err := tx.People().Create(ctx, f.userID(p.UserID))
+			if err != nil {
+				return fmt.Errorf("person %q: %w", p.Name, err)
+			}
+			result.People[p.Name] = person
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// userID resolves a reference to a user, which is either the name of one of
+// the user fixtures or the ID of an existing user.
+func (f *Fixtures) userID(ref string) string {
+	for _, u := range f.Users {
+		if u.Name == ref {
+			return UserID(u.Name)
+		}
+	}
+	return ref
+}
+
+// validate reports every problem with the fixtures at once.
+func (f *Fixtures) validate() error {
+	var problems []string
+
+	users := make(map[string]bool, len(f.Users))
+	for i, u := range f.Users {
+		switch {
+		case u.Name == "":
+			problems = append(problems, fmt.Sprintf("users[%d]: name is required", i))
+		case users[u.Name]:
+			problems = append(problems, fmt.Sprintf("users[%d]: duplicate name %q", i, u.Name))
+		}
+		users[u.Name] = true
+	}
+
+	people := make(map[string]bool, len(f.People))
+	for i, p := range f.People {
+		switch {
+		case p.Name == "":
+			problems = append(problems, fmt.Sprintf("people[%d]: name is required", i))
+		case people[p.Name]:
+			problems = append(problems, fmt.Sprintf("people[%d]: duplicate name %q", i, p.Name))
+		}
+		people[p.Name] = true
+
+		if p.UserID == "" {
+			problems = append(problems, fmt.Sprintf("people[%d]: user_id is required", i))
+		} else if _, err := uuid.Parse(p.UserID); err != nil && !users[p.UserID] {
+			problems = append(problems, fmt.Sprintf("people[%d]: user_id %q is neither a user fixture nor a UUID", i, p.UserID))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid fixtures:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// resetTables lists the tables fixtures are loaded into, each before any table
+// it references, along with the prefix of the outbox topics about their rows.
+var resetTables = []struct {
+	table       string
+	topicPrefix string
+}{
+	{"people", "people."},
+	{"users", "user."},
+}
+
+// Reset empties the tables fixtures are loaded into and restarts their ID
+// sequences, so that reloading fixtures gives the same IDs. The audit log
+// entries and outbox messages about their rows go too, and every instance
+// is told to empty its user cache.
+func Reset(ctx context.Context, db database.DB) error {
+	tables := make([]string, 0, len(resetTables))
+	topicPatterns := make([]string, 0, len(resetTables))
+	for _, t := range resetTables {
+		tables = append(tables, t.table)
+		topicPatterns = append(topicPatterns, t.topicPrefix+"%")
+	}
+
+	return db.WithTransact(ctx, func(tx database.DB) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM audit_log WHERE table_name = ANY($1)", tables); err != nil {
+			return fmt.Errorf("clearing the audit log: %w", err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM outbox WHERE topic LIKE ANY($1)", topicPatterns); err != nil {
+			return fmt.Errorf("clearing the outbox: %w", err)
+		}
+		if _, err := tx.Exec(ctx, "TRUNCATE "+strings.Join(tables, ", ")+" RESTART IDENTITY"); err != nil {
+			return err
+		}
+		return database.ResetUserCaches(ctx, tx)
+	})
+}
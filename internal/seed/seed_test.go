@@ -0,0 +1,209 @@
+package seed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbtest"
+	"github.com/google/uuid"
+)
+
+func TestReadFileYAML(t *testing.T) {
+	const yamlFixtures = `
+users:
+  - name: ada
+    username: ada
+    email: ada@example.com
+people:
+  - name: ada
+    user_id: ada
+`
+	const jsonFixtures = `{
+		"users": [{"name": "ada", "username": "ada", "email": "ada@example.com"}],
+		"people": [{"name": "ada", "user_id": "ada"}]
+	}`
+
+	dir := t.TempDir()
+	read := func(name, data string) (*Fixtures, error) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return ReadFile(path)
+	}
+
+	want, err := read("fixtures.json", jsonFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fixtures.yaml", "fixtures.YML"} {
+		got, err := read(name, yamlFixtures)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	if _, err := read("typo.yaml", "users:\n  - name: ada\n    usernme: ada\n"); err == nil {
+		t.Error("unknown YAML field accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const existingID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	tests := []struct {
+		name     string
+		fixtures Fixtures
+		// want lists the problems reported, in order.
+		want []string
+	}{
+		{
+			name: "valid",
+			fixtures: Fixtures{
+				Users:  []UserFixture{{Name: "ada", Username: "ada", Email: "ada@example.com"}},
+				People: []PersonFixture{{Name: "ada", UserID: "ada"}, {Name: "existing", UserID: existingID}},
+			},
+		},
+		{
+			name: "every problem",
+			fixtures: Fixtures{
+				Users: []UserFixture{{Name: ""}, {Name: "ada"}, {Name: "ada"}},
+				People: []PersonFixture{
+					{Name: "", UserID: "ada"},
+					{Name: "p", UserID: "ada"},
+					{Name: "p", UserID: ""},
+					{Name: "q", UserID: "grace"},
+				},
+			},
+			want: []string{
+				`users[0]: name is required`,
+				`users[2]: duplicate name "ada"`,
+				`people[0]: name is required`,
+				`people[2]: duplicate name "p"`,
+				`people[2]: user_id is required`,
+				`people[3]: user_id "grace" is neither a user fixture nor a UUID`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fixtures.validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			got := strings.Split(err.Error(), "\n\t")[1:]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got problems\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
+
+func TestUserReferences(t *testing.T) {
+	const existingID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	if UserID("ada") != UserID("ada") || UserID("ada") == UserID("grace") {
+		t.Fatal("user IDs are not derived from the fixture name alone")
+	}
+	if _, err := uuid.Parse(UserID("ada")); err != nil {
+		t.Fatalf("user ID is not a UUID: %v", err)
+	}
+
+	users := &Fixtures{Users: []UserFixture{{Name: "ada"}}}
+	people := &Fixtures{People: []PersonFixture{{Name: "ada", UserID: "ada"}}}
+
+	// A person can only refer to a user by name when they are loaded together.
+	if err := people.validate(); err == nil {
+		t.Error("reference to a user fixture in another file accepted")
+	}
+	merged := Merge(users, people)
+	if err := merged.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "ada", want: UserID("ada")},
+		{ref: existingID, want: existingID},
+	}
+	for _, tt := range tests {
+		if got := merged.userID(tt.ref); got != tt.want {
+			t.Errorf("userID(%q) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// TestLoadAndReset runs against the test database (see dbtest), emptying its
+// users and people tables.
+func TestLoadAndReset(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, dbtest.Logger, dbtest.Config(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+	dbtest.Migrate(t, sdb)
+
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(ctx, query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := Reset(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	f := Merge(
+		&Fixtures{Users: []UserFixture{{Name: "ada", Username: "ada", Email: "ada@example.com"}}},
+		&Fixtures{People: []PersonFixture{{Name: "ada", UserID: "ada"}}},
+	)
+	result, err := Load(ctx, db, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Users["ada"].ID; got != UserID("ada") {
+		t.Errorf("got user ID %s, want %s", got, UserID("ada"))
+	}
+	if got := result.People["ada"].UserID; got != UserID("ada") {
+		t.Errorf("got person for user %s, want %s", got, UserID("ada"))
+	}
+	if _, err := db.Outbox().Enqueue(ctx, "user.created", result.Users["ada"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Reset(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"SELECT count(*) FROM users",
+		"SELECT count(*) FROM people",
+		"SELECT count(*) FROM audit_log WHERE table_name IN ('users', 'people')",
+		"SELECT count(*) FROM outbox WHERE topic LIKE 'user.%'",
+	} {
+		if n := count(q); n != 0 {
+			t.Errorf("%s: got %d, want 0 after Reset", q, n)
+		}
+	}
+}
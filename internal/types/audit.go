@@ -0,0 +1,19 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	Table        string          `json:"table"`
+	RecordID     string          `json:"recordId"`
+	Operation    string          `json:"operation"`
+	OldData      json.RawMessage `json:"oldData"`
+	NewData      json.RawMessage `json:"newData"`
+	Actor        string          `json:"actor"`
+	ClaimedActor string          `json:"claimedActor"`
+	RequestID    string          `json:"requestId"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	UniqueKey   *string         `json:"uniqueKey"`
+	Attempts    int32           `json:"attempts"`
+	MaxAttempts int32           `json:"maxAttempts"`
+	LastError   *string         `json:"lastError"`
+	RunAt       time.Time       `json:"runAt"`
+	LockedBy    *string         `json:"lockedBy"`
+	LockedUntil *time.Time      `json:"lockedUntil"`
+	HeartbeatAt *time.Time      `json:"heartbeatAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	FinishedAt  *time.Time      `json:"finishedAt"`
+}
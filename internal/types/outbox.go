@@ -0,0 +1,18 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type OutboxMessage struct {
+	ID          int64           `json:"id"`
+	Topic       string          `json:"topic"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	Attempts    int32           `json:"attempts"`
+	LastError   *string         `json:"lastError"`
+	AvailableAt time.Time       `json:"availableAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	DeliveredAt *time.Time      `json:"deliveredAt"`
+}
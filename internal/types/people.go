@@ -1,6 +1,10 @@
 package types
 
+import "time"
+
 type People struct {
-	ID     int64  `json:"id"`
-	UserID string `json:"userId"`
+	ID        int64      `json:"id"`
+	UserID    string     `json:"userId"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
@@ -2,36 +2,120 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"clevergo.tech/jsend"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/cursor"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/jobs"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/logging"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/outbox"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	migrate "github.com/rubenv/sql-migrate"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "pgx-testrunner: ", log.LstdFlags)
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	db := database.New(ctx, logger)
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			os.Exit(runConfigCommand(cfg, args[1:]))
+		case "migrate":
+			os.Exit(runMigrateCommand(ctx, logger, cfg, args[1:]))
+		case "schema":
+			os.Exit(runSchemaCommand(ctx, logger, cfg, args[1:]))
+		case "seed":
+			os.Exit(runSeedCommand(ctx, logger, cfg, args[1:]))
+		default:
+			logger.Error("unknown command, expected config, migrate, schema or seed", "command", args[0])
+			os.Exit(2)
+		}
+	}
+
+	db, err := database.New(ctx, logger, cfg.Database)
+	if err != nil {
+		logger.Error("error connecting to the database", "error", err)
+		os.Exit(1)
+	}
+
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+	migrations := newMigrationRunner(sdb, logger, cfg.Migrations)
+
+	runMigrations(ctx, migrations, logger, cfg.Migrations)
+
+	// Fail fast if the schema the migrations left us with isn't the one the
+	// stores were written against.
+	if err := database.VerifySchema(ctx, db); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	go func() {
+		if err := db.ListenUserCacheInvalidations(ctx); err != nil {
+			logger.Error("error listening for user cache invalidations", "error", err)
+		}
+	}()
+
+	dispatcher := outbox.NewDispatcher(db, outbox.LogPublisher{Logger: logger}, logger)
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(ctx)
+	}()
 
-	runMigrations(db, logger)
+	// Job kinds are registered here with jobs.Register before the pool starts.
+	registry := jobs.NewRegistry()
+	workers := jobs.NewPool(db, registry, logger)
+	workers.Start()
 
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		jsend.Error(w, "not found", http.StatusNotFound)
 	})
-	r.Use(middleware.AllowContentType("application/json"))
+	r.Use(middleware.AllowContentType("application/json", mergePatchContentType, jsonPatchContentType))
+
+	cursors := cursor.NewCodec([]byte(cfg.HTTP.CursorKey.Value()))
+	if cfg.HTTP.CursorKey == "" {
+		logger.Warn("http.cursorKey is not set, so pagination cursors won't survive a restart or work across instances")
+		if cursors, err = cursor.NewRandomCodec(); err != nil {
+			logger.Error("error generating a cursor key", "error", err)
+			os.Exit(1)
+		}
+	}
 
-	s := newServer(db, r)
+	s := newServer(db, migrations, r, cfg.HTTP, cursors)
 	s.setupRoutes()
 
 	// Create a channel to receive the interrupt signal
@@ -40,26 +124,75 @@ func main() {
 	// Notify the channel for the interrupt signal
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
 
-	go s.start()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.start() }()
 
-	<-interruptChan
+	exitCode := 0
+	select {
+	case sig := <-interruptChan:
+		logger.Info("shutting down", "signal", sig.String(), "timeout", cfg.HTTP.ShutdownTimeout)
+	case err := <-serveErr:
+		logger.Error("http server failed", "error", err)
+		exitCode = 1
+	}
 
-	s.gracefulShutdown()
-	logger.Println("Server stopped gracefully")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := shutdown(shutdownCtx, s, cancel, dispatcherDone, workers, db); err != nil {
+		logger.Error("graceful shutdown did not finish in time", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("server stopped gracefully")
+	os.Exit(exitCode)
 }
 
-func runMigrations(db database.DB, logger *log.Logger) {
-	migrations := &migrate.FileMigrationSource{
-		Dir: "migrations",
+// shutdown stops the service in an order that lets work in progress finish:
+// requests in flight are drained, then the background workers stop, then open
+// transactions are waited for, and only then is the pool closed. It gives up,
+// leaving the pool open, once ctx is done.
+func shutdown(ctx context.Context, s *server, stopBackground context.CancelFunc, dispatcherDone <-chan struct{}, workers *jobs.Pool, db database.DB) error {
+	if err := s.shutdown(ctx); err != nil {
+		return fmt.Errorf("draining requests: %w", err)
 	}
 
-	sdb := db.GetSQLDB()
-	defer sdb.Close()
+	stopBackground()
+	select {
+	case <-dispatcherDone:
+	case <-ctx.Done():
+		return fmt.Errorf("stopping the outbox dispatcher: %w", ctx.Err())
+	}
+	if err := workers.Stop(ctx); err != nil {
+		return fmt.Errorf("waiting for jobs to finish: %w", err)
+	}
 
-	n, err := migrate.Exec(sdb, "postgres", migrations, migrate.Up)
-	if err != nil {
-		logger.Println("error running migrations:", err)
-		os.Exit(1)
+	// Closing the pool waits for every connection to be released, so make sure
+	// nothing is still using one first.
+	if err := db.WaitForTransactions(ctx); err != nil {
+		return fmt.Errorf("waiting for transactions to finish: %w", err)
+	}
+	db.Close()
+	return nil
+}
+
+func runMigrations(ctx context.Context, runner *migration.Runner, logger *slog.Logger, cfg config.Migrations) {
+	switch cfg.Mode {
+	case "apply":
+		n, err := runner.Up(ctx, 0)
+		if err != nil {
+			logger.Error("error running migrations", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("applied migrations", "count", n)
+
+	case "check":
+		pending, err := runner.Pending()
+		if err != nil {
+			logger.Error("error checking migrations", "error", err)
+			os.Exit(1)
+		}
+		if len(pending) > 0 {
+			logger.Error("refusing to start with pending migrations; run the migrate command first", "pending", strings.Join(pending, ", "))
+			os.Exit(1)
+		}
 	}
-	logger.Printf("Applied %d migrations!\n", n)
 }
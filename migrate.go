@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const migrateUsage = `usage: %s [config flags] migrate [flags] <command>
+
+commands:
+  up [n]       apply n pending migrations (all if n is omitted)
+  down [n]     roll back the n most recent migrations (1 if n is omitted),
+               where n must be at least 1
+  down --all   roll back every migration
+  redo         roll back the most recent migration and apply it again
+  status       list migrations and whether they have been applied
+  roundtrip    check in a scratch database that every migration can be
+               rolled back and applied again
+  new <name>   create an empty migration in -dir
+
+flags:
+`
+
+// runMigrateCommand implements the migrate subcommand and returns the process
+// exit code.
+func runMigrateCommand(ctx context.Context, logger *slog.Logger, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the SQL up, down and redo would run without running it")
+	dir := fs.String("dir", "migrations", "directory new migrations are created in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), migrateUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+
+	// Creating a migration only touches the source tree.
+	if cmd == "new" {
+		if len(cmdArgs) != 1 {
+			fs.Usage()
+			return 2
+		}
+		path, err := migration.Create(*dir, cmdArgs[0], time.Now())
+		if err != nil {
+			logger.Error("error creating migration", "error", err)
+			return 1
+		}
+		fmt.Println(path)
+		return 0
+	}
+
+	db, err := database.New(ctx, logger, cfg.Database)
+	if err != nil {
+		logger.Error("error connecting to the database", "error", err)
+		return 1
+	}
+	defer db.Close()
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+	runner := newMigrationRunner(sdb, logger, cfg.Migrations)
+
+	if cmd == "roundtrip" {
+		connConfig, err := database.ConnConfig(ctx, logger, cfg.Database)
+		if err == nil {
+			err = migration.WithScratchDatabase(ctx, sdb, connConfig, func(scratch *sql.DB) error {
+				return newMigrationRunner(scratch, logger, cfg.Migrations).RoundTrip(ctx)
+			})
+		}
+		if err != nil {
+			logger.Error("migration round trip failed", "error", err)
+			return 1
+		}
+		fmt.Println("every migration rolls back and reapplies cleanly")
+		return 0
+	}
+
+	if err := runMigrate(ctx, runner, cmd, cmdArgs, *dryRun); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		logger.Error("error running migrate", "command", cmd, "error", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+func newMigrationRunner(db *sql.DB, logger *slog.Logger, cfg config.Migrations) *migration.Runner {
+	runner := migration.NewRunner(db, logger)
+	runner.LockTimeout = cfg.LockTimeout
+	runner.StatementLockTimeout = cfg.StatementLockTimeout
+	return runner
+}
+
+func runMigrate(ctx context.Context, runner *migration.Runner, cmd string, args []string, dryRun bool) error {
+	switch cmd {
+	case "up", "down":
+		dir, n, atLeast := migrate.Up, 0, 0
+		if cmd == "down" {
+			// 0 means every migration to the runner, which is too easy to
+			// ask for by accident when rolling back, so it takes --all.
+			dir, n, atLeast = migrate.Down, 1, 1
+
+			fs := flag.NewFlagSet("down", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			all := fs.Bool("all", false, "")
+			if err := fs.Parse(args); err != nil {
+				return errUsage
+			}
+			args = fs.Args()
+			if *all {
+				if len(args) != 0 {
+					return errUsage
+				}
+				n, atLeast = 0, 0
+			}
+		}
+		if len(args) > 1 {
+			return errUsage
+		}
+		if len(args) == 1 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < atLeast {
+				return errUsage
+			}
+		}
+
+		if dryRun {
+			planned, err := runner.Plan(dir, n)
+			if err != nil {
+				return err
+			}
+			printPlan(os.Stdout, planned)
+			return nil
+		}
+
+		var applied int
+		var err error
+		switch {
+		case dir == migrate.Up:
+			applied, err = runner.Up(ctx, n)
+		case n == 0:
+			applied, err = runner.DownAll(ctx)
+		default:
+			applied, err = runner.Down(ctx, n)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d migrations\n", cmd, applied)
+		return nil
+
+	case "redo":
+		if len(args) != 0 {
+			return errUsage
+		}
+		if dryRun {
+			down, err := runner.Plan(migrate.Down, 1)
+			if err != nil {
+				return err
+			}
+			printPlan(os.Stdout, down)
+			for _, m := range down {
+				printPlan(os.Stdout, []*migrate.PlannedMigration{{Migration: m.Migration, Queries: m.Up}})
+			}
+			return nil
+		}
+		return runner.Redo(ctx)
+
+	case "status":
+		if len(args) != 0 {
+			return errUsage
+		}
+		statuses, err := runner.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", s.ID, appliedAt)
+		}
+		return w.Flush()
+	}
+
+	return errUsage
+}
+
+func printPlan(w io.Writer, planned []*migrate.PlannedMigration) {
+	if len(planned) == 0 {
+		fmt.Fprintln(w, "-- nothing to do")
+	}
+	for _, m := range planned {
+		fmt.Fprintf(w, "-- %s\n", m.Id)
+		for _, q := range m.Queries {
+			fmt.Fprintln(w, strings.TrimSpace(q))
+		}
+		fmt.Fprintln(w)
+	}
+}
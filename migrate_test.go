@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunMigrateDownUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "zero", args: []string{"0"}},
+		{name: "negative", args: []string{"-1"}},
+		{name: "not a number", args: []string{"all"}},
+		{name: "all with a count", args: []string{"--all", "2"}},
+		{name: "too many arguments", args: []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Usage errors are caught before the runner is used.
+			err := runMigrate(context.Background(), nil, "down", tt.args, false)
+			if !errors.Is(err, errUsage) {
+				t.Errorf("got %v, want a usage error", err)
+			}
+		})
+	}
+}
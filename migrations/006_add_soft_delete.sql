@@ -0,0 +1,47 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE people ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Only live users need unique usernames and emails, so that a deleted user's
+-- email can be used to sign up again.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_live_key ON users (username) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_key ON users (email) WHERE deleted_at IS NULL;
+
+-- +migrate Down
+-- Without deleted_at, soft-deleted rows would come back as live ones, whose
+-- usernames and emails may since have been taken by live users, which the
+-- plain unique constraints below don't allow. Rather than deleting them here,
+-- which can't be undone, rolling back refuses to run until an operator has
+-- purged or restored them.
+-- +migrate StatementBegin
+DO $$
+DECLARE
+	deleted_users bigint;
+	deleted_people bigint;
+	collisions bigint;
+BEGIN
+	SELECT count(*) INTO deleted_users FROM users WHERE deleted_at IS NOT NULL;
+	SELECT count(*) INTO deleted_people FROM people WHERE deleted_at IS NOT NULL;
+	SELECT count(DISTINCT deleted.id) INTO collisions
+	FROM users deleted
+	JOIN users live ON live.deleted_at IS NULL
+		AND (live.username = deleted.username OR live.email = deleted.email)
+	WHERE deleted.deleted_at IS NOT NULL;
+
+	IF deleted_users > 0 OR deleted_people > 0 THEN
+		RAISE EXCEPTION 'cannot roll back soft deletes: % soft-deleted users (% sharing a username or email with a live user) and % soft-deleted people must be purged or restored first',
+			deleted_users, collisions, deleted_people;
+	END IF;
+END
+$$;
+-- +migrate StatementEnd
+
+DROP INDEX IF EXISTS users_email_live_key;
+DROP INDEX IF EXISTS users_username_live_key;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE people DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,26 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    state TEXT NOT NULL DEFAULT 'queued',
+    unique_key TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 25,
+    last_error TEXT,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_by TEXT,
+    locked_until TIMESTAMPTZ,
+    heartbeat_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (run_at, id) WHERE state = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_until) WHERE state = 'running';
+-- At most one unfinished job per kind and unique key.
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (kind, unique_key)
+    WHERE unique_key IS NOT NULL AND state IN ('queued', 'running');
+
+-- +migrate Down
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,8 @@
+-- +migrate Up notransaction
+-- Built concurrently so that creating it does not block writes to users. A
+-- failed build leaves an INVALID index, which the migration runner drops
+-- before retrying.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email_lower_idx ON users (lower(email)) WHERE deleted_at IS NULL;
+
+-- +migrate Down notransaction
+DROP INDEX CONCURRENTLY IF EXISTS users_email_lower_idx;
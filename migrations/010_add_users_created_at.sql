@@ -0,0 +1,7 @@
+-- +migrate Up
+-- Users created before this migration get its time as their creation time,
+-- as the real one was never recorded.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
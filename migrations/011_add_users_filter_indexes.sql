@@ -0,0 +1,17 @@
+-- +migrate Up notransaction
+-- pg_trgm lets the substring filters on usernames and emails use an index.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_created_at_idx ON users (created_at) WHERE deleted_at IS NULL;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email_domain_idx ON users (lower(split_part(email, '@', 2))) WHERE deleted_at IS NULL;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_username_pattern_idx ON users (username text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_username_trgm_idx ON users USING gin (username gin_trgm_ops) WHERE deleted_at IS NULL;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_email_trgm_idx ON users USING gin (email gin_trgm_ops) WHERE deleted_at IS NULL;
+
+-- +migrate Down notransaction
+DROP INDEX CONCURRENTLY IF EXISTS users_email_trgm_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_username_trgm_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_username_pattern_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_email_domain_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_created_at_idx;
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- The actor of every entry so far was taken from the unauthenticated X-Actor
+-- header, so it is only what the caller claimed to be.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS claimed_actor TEXT NOT NULL DEFAULT '';
+UPDATE audit_log SET claimed_actor = actor, actor = '' WHERE actor <> '';
+
+-- +migrate Down
+UPDATE audit_log SET actor = claimed_actor WHERE actor = '';
+ALTER TABLE audit_log DROP COLUMN IF EXISTS claimed_actor;
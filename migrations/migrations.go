@@ -0,0 +1,17 @@
+// Package migrations embeds the SQL migrations so the binary can apply them
+// without the source tree.
+package migrations
+
+import (
+	"embed"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Source returns the embedded migrations.
+func Source() migrate.MigrationSource {
+	return &migrate.EmbedFileSystemMigrationSource{FileSystem: files, Root: "."}
+}
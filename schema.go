@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
+)
+
+const schemaUsage = `usage: %s [config flags] schema diff
+
+Compares the live database against the schema the migrations produce in a
+scratch database, to catch changes made by hand. Lines starting with - are
+missing from the live database and lines starting with + exist only there.
+`
+
+// runSchemaCommand implements the schema subcommand and returns the process
+// exit code.
+func runSchemaCommand(ctx context.Context, logger *slog.Logger, cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "diff" {
+		fmt.Fprintf(os.Stderr, schemaUsage, os.Args[0])
+		return 2
+	}
+
+	db, err := database.New(ctx, logger, cfg.Database)
+	if err != nil {
+		logger.Error("error connecting to the database", "error", err)
+		return 1
+	}
+	defer db.Close()
+	sdb := db.GetSQLDB()
+	defer sdb.Close()
+
+	connConfig, err := database.ConnConfig(ctx, logger, cfg.Database)
+	if err != nil {
+		logger.Error("error reading database config", "error", err)
+		return 1
+	}
+
+	want, err := migration.MigratedSnapshot(ctx, sdb, connConfig, newMigrationRunner(sdb, logger, cfg.Migrations))
+	if err != nil {
+		logger.Error("error applying migrations to a scratch database", "error", err)
+		return 1
+	}
+	have, err := migration.TakeSnapshot(ctx, sdb)
+	if err != nil {
+		logger.Error("error reading the live schema", "error", err)
+		return 1
+	}
+
+	diff := have.Diff(want)
+	if len(diff) == 0 {
+		fmt.Println("the live schema matches the migrations")
+		return 0
+	}
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+	return 1
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/seed"
+)
+
+const seedUsage = `usage: %s [config flags] seed [flags] <fixtures>...
+
+Loads fixtures into the database, all files in a single transaction, so that
+fixtures can refer to those in other files. Files named .yaml or .yml are read
+as YAML and any others as JSON.
+
+flags:
+`
+
+// runSeedCommand implements the seed subcommand and returns the process exit
+// code.
+func runSeedCommand(ctx context.Context, logger *slog.Logger, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	reset := fs.Bool("reset", false, "empty the users and people tables, and their audit log and outbox entries, before loading")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), seedUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 && !*reset {
+		fs.Usage()
+		return 2
+	}
+
+	var fixtures []*seed.Fixtures
+	for _, path := range fs.Args() {
+		f, err := seed.ReadFile(path)
+		if err != nil {
+			logger.Error("error reading fixtures", "error", err)
+			return 1
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	db, err := database.New(ctx, logger, cfg.Database)
+	if err != nil {
+		logger.Error("error connecting to the database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	if *reset {
+		if err := seed.Reset(ctx, db); err != nil {
+			logger.Error("error resetting tables", "error", err)
+			return 1
+		}
+	}
+
+	if len(fixtures) == 0 {
+		return 0
+	}
+	result, err := seed.Load(ctx, db, seed.Merge(fixtures...))
+	if err != nil {
+		logger.Error("error loading fixtures", "error", err)
+		return 1
+	}
+	fmt.Printf("loaded %d users and %d people\n", len(result.Users), len(result.People))
+	return 0
+}
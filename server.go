@@ -1,40 +1,114 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"clevergo.tech/jsend"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/cursor"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/dbutil"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/jsonpatch"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/logging"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/migration"
 	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 )
 
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
 type server struct {
-	db     database.DB
-	router *chi.Mux
+	db         database.DB
+	migrations *migration.Runner
+	router     *chi.Mux
+	httpServer *http.Server
+	// cursors signs the pagination cursors handed to clients.
+	cursors *cursor.Codec
+	// adminToken is the bearer token requireAdmin checks for.
+	adminToken config.Secret
+
+	// shuttingDown is set once graceful shutdown starts, failing readiness.
+	shuttingDown atomic.Bool
+	// drainDelay is how long shutdown waits after failing readiness before it
+	// stops accepting connections.
+	drainDelay time.Duration
+	// pendingMigrations caches the migration check behind readyz.
+	pendingMigrations pendingMigrationsCheck
 }
 
-func newServer(db database.DB, r *chi.Mux) *server {
+func newServer(db database.DB, migrations *migration.Runner, r *chi.Mux, cfg config.HTTP, cursors *cursor.Codec) *server {
 	return &server{
-		db:     db,
-		router: r,
+		db:         db,
+		migrations: migrations,
+		router:     r,
+		cursors:    cursors,
+		adminToken: cfg.AdminToken,
+		drainDelay: cfg.DrainDelay,
+		httpServer: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           r,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
 	}
 }
 
-func (s *server) start() {
-	http.ListenAndServe(":3000", s.router)
+// start serves requests until shutdown is called, returning nil, or the server
+// fails, e.g. because the address is in use.
+func (s *server) start() error {
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-func (s *server) gracefulShutdown() {
-	s.db.Close()
+// shutdown makes readiness fail, so that load balancers stop sending us
+// requests, keeps serving for the drain delay while they notice, and then
+// stops accepting connections and waits for requests in flight to finish, or
+// for ctx to be done.
+func (s *server) shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+
+	select {
+	case <-time.After(s.drainDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.httpServer.Shutdown(ctx)
 }
 
 func (s *server) setupRoutes() {
+	s.router.Use(auditContext)
+
 	s.router.Get("/", s.rootHandler)
+	s.router.Get("/healthz", s.healthz)
+	s.router.Get("/readyz", s.readyz)
+	s.router.Group(func(ir chi.Router) {
+		ir.Use(s.requireAdmin)
+		ir.Get("/debug/db", s.debugDB)
+		ir.Get("/audit", s.getAuditLog)
+	})
 	s.router.Route("/people", func(ir chi.Router) {
 		ir.Get("/", s.getPeople)
 		ir.Post("/", s.createPeople)
@@ -43,7 +117,45 @@ func (s *server) setupRoutes() {
 		ir.Get("/", s.getUsers)
 		ir.Get("/{userID}", s.getUser)
 		ir.Post("/", s.createUser)
-		ir.Delete("/", s.deleteUser)
+		ir.Put("/{userID}", s.updateUser)
+		ir.Patch("/{userID}", s.patchUser)
+		ir.Delete("/{userID}", s.deleteUser)
+		ir.Post("/{userID}/restore", s.restoreUser)
+	})
+}
+
+// auditContext records who is making the request, and under which request ID, so
+// the stores can attribute their audit entries. Until we have authentication
+// nobody is attributed as the actor, and whatever the caller puts in the
+// X-Actor header is only recorded as the claimed actor.
+func auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetReqID(r.Context())
+		ctx := database.WithRequestID(r.Context(), requestID)
+		ctx = logging.WithAttrs(ctx, slog.String("request_id", requestID))
+		if actor := r.Header.Get("X-Actor"); actor != "" {
+			ctx = database.WithClaimedActor(ctx, actor)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin only lets through requests bearing the admin token, for the
+// endpoints meant for operators. They are disabled if no token is configured.
+func (s *server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			jsend.Error(w, "operator endpoints are disabled: http.adminToken is not set", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken.Value())) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			jsend.Error(w, "a valid admin token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -55,13 +167,241 @@ func (s *server) getPeople(w http.ResponseWriter, r *http.Request) {
 	jsend.Success(w, "hello people", http.StatusOK)
 }
 
+// userPage is a page of users as returned by GET /user.
+type userPage struct {
+	Users    []*types.User `json:"users"`
+	PageInfo pageInfo      `json:"pageInfo"`
+}
+
+type pageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor,omitempty"`
+	EndCursor       string `json:"endCursor,omitempty"`
+}
+
+// getUsers lists users a page at a time: the first users after the cursor in
+// after, or the last ones before the cursor in before, with first and last
+// saying how many. Admin tooling can use limit and offset instead. The next
+// and previous pages are linked to in the Link header. Users can be filtered
+// with filter[field][op]=value parameters, as described by userFilters.
 func (s *server) getUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := s.db.Users().List(r.Context(), database.ListUserArgs{})
+	query := r.URL.Query()
+	includeDeleted, _ := strconv.ParseBool(query.Get("includeDeleted"))
+	opts := database.ListUserArgs{IncludeDeleted: includeDeleted}
+	if err := parseUserFilters(query, &opts); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var limits []int
+	for _, name := range []string{"first", "last", "limit"} {
+		if query.Has(name) {
+			n, err := intQueryParam(query, name)
+			if err != nil {
+				jsend.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			limits = append(limits, n)
+		}
+	}
+	if len(limits) > 1 {
+		jsend.Error(w, "only one of first, last and limit can be given", http.StatusBadRequest)
+		return
+	}
+	if len(limits) == 1 {
+		opts.Limit = limits[0]
+	}
+
+	var err error
+	if opts.Offset, err = intQueryParam(query, "offset"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.After, err = s.userCursorQueryParam(query, "after"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Before, err = s.userCursorQueryParam(query, "before"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Has("first") && opts.Before != nil && opts.After == nil {
+		jsend.Error(w, "first is used with after, and last with before", http.StatusBadRequest)
+		return
+	}
+	// There is no cursor for the end of the list to page back from, so last
+	// without before would silently return the first users instead.
+	if query.Has("last") && (opts.Before == nil || opts.After != nil) {
+		jsend.Error(w, "first is used with after, and last with before", http.StatusBadRequest)
+		return
+	}
+
+	users, info, err := s.db.Users().ListPage(r.Context(), opts)
 	if err != nil {
 		jsend.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	jsend.Success(w, users, http.StatusOK)
+
+	page := userPage{
+		Users: users,
+		PageInfo: pageInfo{
+			HasNextPage:     info.HasNextPage,
+			HasPreviousPage: info.HasPreviousPage,
+		},
+	}
+	if info.StartCursor != nil {
+		if page.PageInfo.StartCursor, err = s.cursors.Encode(info.StartCursor); err != nil {
+			jsend.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if page.PageInfo.EndCursor, err = s.cursors.Encode(info.EndCursor); err != nil {
+			jsend.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Keep the page size the client asked for, if any, as this page may have
+	// come up short.
+	var pageSize string
+	if len(limits) == 1 {
+		pageSize = strconv.Itoa(limits[0])
+	}
+	var links []string
+	if page.PageInfo.HasNextPage && page.PageInfo.EndCursor != "" {
+		links = append(links, pageLink(r.URL, "next", "first", pageSize, "after", page.PageInfo.EndCursor))
+	}
+	if page.PageInfo.HasPreviousPage && page.PageInfo.StartCursor != "" {
+		links = append(links, pageLink(r.URL, "prev", "last", pageSize, "before", page.PageInfo.StartCursor))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	jsend.Success(w, page, http.StatusOK)
+}
+
+// userCursorQueryParam decodes the cursor in the named query parameter, if
+// there is one.
+func (s *server) userCursorQueryParam(query url.Values, name string) (*database.UserCursor, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	var c database.UserCursor
+	if err := s.cursors.Decode(v, &c); err != nil {
+		return nil, fmt.Errorf("%s is not a valid cursor", name)
+	}
+	return &c, nil
+}
+
+// pageLink returns a Link header value pointing at the page of count results,
+// or the default number if count is empty, on the given side of cursor,
+// keeping the rest of the query, such as filters, as it was.
+func pageLink(u *url.URL, rel, countParam, count, cursorParam, cursor string) string {
+	query := u.Query()
+	for _, name := range []string{"first", "last", "limit", "offset", "after", "before"} {
+		query.Del(name)
+	}
+	if count != "" {
+		query.Set(countParam, count)
+	}
+	query.Set(cursorParam, cursor)
+
+	link := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", link.String(), rel)
+}
+
+func (s *server) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := database.ListAuditArgs{
+		Table:        query.Get("table"),
+		RecordID:     query.Get("recordId"),
+		Operation:    query.Get("operation"),
+		Actor:        query.Get("actor"),
+		ClaimedActor: query.Get("claimedActor"),
+		RequestID:    query.Get("requestId"),
+	}
+
+	var err error
+	if opts.Limit, err = intQueryParam(query, "limit"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Offset, err = intQueryParam(query, "offset"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Since, err = timeQueryParam(query, "since"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Until, err = timeQueryParam(query, "until"); err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.db.Audit().List(r.Context(), opts)
+	if err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, entry := range entries {
+		entry.OldData = redactAuditData(entry.OldData)
+		entry.NewData = redactAuditData(entry.NewData)
+	}
+	jsend.Success(w, entries, http.StatusOK)
+}
+
+// auditPIIFields are the fields of audited rows that hold personal data, which
+// the audit log API doesn't hand out.
+var auditPIIFields = []string{"email"}
+
+// redactAuditData replaces the personal data in an audited row. Anything that
+// isn't a JSON object, such as null, is returned as is.
+func redactAuditData(data json.RawMessage) json.RawMessage {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil || row == nil {
+		return data
+	}
+
+	for _, field := range auditPIIFields {
+		if _, ok := row[field]; ok {
+			row[field] = json.RawMessage(`"REDACTED"`)
+		}
+	}
+	redacted, err := json.Marshal(row)
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+func intQueryParam(query url.Values, name string) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+func timeQueryParam(query url.Values, name string) (time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t, nil
 }
 
 func (s *server) getUser(w http.ResponseWriter, r *http.Request) {
@@ -78,14 +418,205 @@ func (s *server) getUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := s.db.Users().GetByID(r.Context(), userID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if database.IsUserNotFoundErr(err) {
+			status = http.StatusNotFound
+		}
+		jsend.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	jsend.Success(w, user, http.StatusOK)
+}
+
+func (s *server) updateUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	if _, err := uuid.Parse(userID); err != nil {
+		jsend.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		jsend.Error(w, "If-Match header is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	precondition, err := parseIfMatch(ifMatch)
+	if err != nil {
+		jsend.Error(w, err.Error(), etagErrorStatus(err))
+		return
+	}
+
+	var body struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		jsend.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if body.Email == "" {
+		jsend.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	if body.Username == "" {
+		jsend.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var user *types.User
+	err = s.db.WithTransact(ctx, func(tx database.DB) error {
+		current, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !precondition.matches(current.Version) {
+			return errPreconditionFailed
+		}
+
+		user, err = tx.Users().Update(ctx, userID, database.UserUpdate{
+			Email:           &body.Email,
+			Username:        &body.Username,
+			ExpectedVersion: &current.Version,
+		})
+		return err
+	})
+	if err != nil {
+		jsend.Error(w, err.Error(), updateErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
 	jsend.Success(w, user, http.StatusOK)
 }
 
+// userPatchDocument is the part of a user that PATCH can change, and so the
+// document patches are applied to.
+type userPatchDocument struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// patchUser applies a JSON Merge Patch (RFC 7396), or a JSON Patch (RFC 6902)
+// if the request says so with its Content-Type, to the user's email and
+// username. If-Match is optional: the patch is applied to the user as read in
+// the same transaction as the update either way.
+func (s *server) patchUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	if _, err := uuid.Parse(userID); err != nil {
+		jsend.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	var precondition *etagPrecondition
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		p, err := parseIfMatch(ifMatch)
+		if err != nil {
+			jsend.Error(w, err.Error(), etagErrorStatus(err))
+			return
+		}
+		precondition = &p
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsend.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyPatch := jsonpatch.Merge
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == jsonPatchContentType {
+		applyPatch = jsonpatch.Apply
+	}
+
+	ctx := r.Context()
+	var updated *types.User
+	status := http.StatusBadRequest
+	err = s.db.WithTransact(ctx, func(tx database.DB) error {
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			if database.IsUserNotFoundErr(err) {
+				status = http.StatusNotFound
+			}
+			return err
+		}
+
+		if precondition != nil && !precondition.matches(user.Version) {
+			status = http.StatusPreconditionFailed
+			return errPreconditionFailed
+		}
+
+		doc, err := json.Marshal(userPatchDocument{Email: user.Email, Username: user.Username})
+		if err != nil {
+			return err
+		}
+		patched, err := applyPatch(doc, patch)
+		if err != nil {
+			switch {
+			case jsonpatch.IsInvalidPatchErr(err):
+				status = http.StatusBadRequest
+			case jsonpatch.IsTestFailedErr(err):
+				status = http.StatusConflict
+			default:
+				status = http.StatusUnprocessableEntity
+			}
+			return err
+		}
+
+		var changes userPatchDocument
+		dec := json.NewDecoder(bytes.NewReader(patched))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&changes); err != nil {
+			return fmt.Errorf("only email and username can be patched: %w", err)
+		}
+		if changes.Email == "" {
+			return errors.New("email is required")
+		}
+		if changes.Username == "" {
+			return errors.New("username is required")
+		}
+
+		updated, err = tx.Users().Update(ctx, userID, database.UserUpdate{
+			Email:           &changes.Email,
+			Username:        &changes.Username,
+			ExpectedVersion: &user.Version,
+		})
+		if err != nil {
+			status = updateErrorStatus(err)
+		}
+		return err
+	})
+	if err != nil {
+		jsend.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(updated.Version))
+	jsend.Success(w, updated, http.StatusOK)
+}
+
+// updateErrorStatus returns the status for an error from UserStore.Update, or
+// from checking If-Match before it.
+func updateErrorStatus(err error) int {
+	switch {
+	case database.IsStaleWriteErr(err), errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case database.IsUserNotFoundErr(err):
+		return http.StatusNotFound
+	case database.IsUserConflictErr(err), dbutil.IsUniqueViolation(err):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
 func (s *server) createUser(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Email    string `json:"email"`
@@ -126,13 +657,16 @@ func (s *server) createUser(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
-		fmt.Println("created user, ===>>", newUser.ID)
-
 		_, err = tx.People().Create(ctx, newUser.ID)
 		if err != nil {
 			return err
 		}
 
+		_, err = tx.Outbox().Enqueue(ctx, "user.created", newUser)
+		if err != nil {
+			return err
+		}
+
 		status = http.StatusOK
 		return nil
 	})
@@ -144,10 +678,148 @@ func (s *server) createUser(w http.ResponseWriter, r *http.Request) {
 	jsend.Success(w, newUser, status)
 }
 
+// versionETag renders a row version as a strong entity tag.
+func versionETag(version int32) string {
+	return strconv.Quote(strconv.FormatInt(int64(version), 10))
+}
+
+// errWeakETag is returned for an If-Match with only weak entity tags, which
+// never match since If-Match compares tags strongly, so the precondition fails.
+var errWeakETag = errors.New("If-Match requires a strong entity tag")
+
+// errPreconditionFailed is returned when If-Match names none of the user's
+// current entity tags.
+var errPreconditionFailed = errors.New("If-Match does not match the user's current entity tag")
+
+// etagPrecondition is a parsed If-Match header: either * or a list of tags.
+type etagPrecondition struct {
+	// any is set for *, which holds for any version of an existing user.
+	any      bool
+	versions []int32
+}
+
+// matches reports whether the precondition holds for a user at version.
+func (p etagPrecondition) matches(version int32) bool {
+	if p.any {
+		return true
+	}
+	for _, v := range p.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIfMatch parses an If-Match header, which is * or a comma-separated
+// list of entity tags, any of which the user's must match. Weak tags are
+// skipped since they never match.
+func parseIfMatch(header string) (etagPrecondition, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return etagPrecondition{any: true}, nil
+	}
+
+	var p etagPrecondition
+	var weak bool
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+			// Lists may have empty elements.
+		case strings.HasPrefix(tag, "W/"):
+			weak = true
+		default:
+			version, err := parseVersionETag(tag)
+			if err != nil {
+				return etagPrecondition{}, err
+			}
+			p.versions = append(p.versions, version)
+		}
+	}
+	if len(p.versions) == 0 {
+		if weak {
+			return etagPrecondition{}, errWeakETag
+		}
+		return etagPrecondition{}, fmt.Errorf("malformed If-Match header %q", header)
+	}
+	return p, nil
+}
+
+// parseVersionETag reverses versionETag.
+func parseVersionETag(tag string) (int32, error) {
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, fmt.Errorf("malformed entity tag %s", tag)
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed entity tag %s", tag)
+	}
+	return int32(version), nil
+}
+
+// etagErrorStatus returns the status code for an If-Match header parseIfMatch
+// rejected.
+func etagErrorStatus(err error) int {
+	if errors.Is(err, errWeakETag) {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusBadRequest
+}
+
 func (s *server) createPeople(w http.ResponseWriter, r *http.Request) {
 	jsend.Success(w, "hello create people", http.StatusCreated)
 }
 
 func (s *server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	if _, err := uuid.Parse(userID); err != nil {
+		jsend.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	purge, _ := strconv.ParseBool(r.URL.Query().Get("purge"))
+
+	var err error
+	if purge {
+		err = s.db.Users().Purge(r.Context(), userID)
+	} else {
+		err = s.db.Users().Delete(r.Context(), userID)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if database.IsUserNotFoundErr(err) {
+			status = http.StatusNotFound
+		}
+		jsend.Error(w, err.Error(), status)
+		return
+	}
+
 	jsend.Success(w, nil, http.StatusNoContent)
 }
+
+func (s *server) restoreUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	if _, err := uuid.Parse(userID); err != nil {
+		jsend.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.Users().Restore(r.Context(), userID)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case database.IsUserNotFoundErr(err):
+			status = http.StatusNotFound
+		case dbutil.IsUniqueViolation(err):
+			status = http.StatusConflict
+		}
+		jsend.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	jsend.Success(w, user, http.StatusOK)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/config"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/cursor"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/database/basestore"
+	"github.com/BolajiOlajide/pgx-poc-db-store/internal/types"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const testUserID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+// fakeTx is a transaction whose queries for a single user find the given
+// users in turn, with nil or running out of them meaning no row, and in which
+// every other statement succeeds.
+type fakeTx struct {
+	pgx.Tx
+	users []*types.User
+}
+
+func (t *fakeTx) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (t *fakeTx) QueryRow(context.Context, string, ...any) pgx.Row {
+	if len(t.users) == 0 {
+		return fakeRow{}
+	}
+	user := t.users[0]
+	t.users = t.users[1:]
+	return fakeRow{user: user}
+}
+
+func (t *fakeTx) Rollback(context.Context) error { return nil }
+
+type fakeRow struct {
+	user *types.User
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.user == nil {
+		return pgx.ErrNoRows
+	}
+	*dest[0].(*string) = r.user.ID
+	*dest[1].(*string) = r.user.Username
+	*dest[2].(*string) = r.user.Email
+	*dest[3].(*int32) = r.user.Version
+	*dest[4].(*time.Time) = r.user.CreatedAt
+	*dest[5].(**time.Time) = r.user.DeletedAt
+	return nil
+}
+
+// fakeDB serves users from the real user store running on a fakeTx, so that
+// store errors reach the handlers wrapped by the transaction and savepoint
+// handles just as they are against a real database.
+type fakeDB struct {
+	database.DB
+	store *basestore.Store
+}
+
+func newFakeDB(users ...*types.User) *fakeDB {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handle := basestore.NewHandleWithTx(logger, &fakeTx{users: users}, pgx.TxOptions{})
+	return &fakeDB{store: basestore.NewWithHandle(handle)}
+}
+
+func (d *fakeDB) Users() database.UserStore {
+	return database.UsersWith(d.store)
+}
+
+func (d *fakeDB) WithTransact(ctx context.Context, f func(tx database.DB) error) error {
+	return d.store.WithTransact(ctx, func(tx *basestore.Store) error {
+		return f(&fakeDB{store: tx})
+	})
+}
+
+func newTestServer(t *testing.T, db database.DB) http.Handler {
+	t.Helper()
+
+	cursors, err := cursor.NewRandomCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(db, nil, chi.NewRouter(), config.HTTP{}, cursors)
+	s.setupRoutes()
+	return s.router
+}
+
+func TestUpdateUserStatus(t *testing.T) {
+	user := &types.User{ID: testUserID, Username: "alice", Email: "alice@example.com", Version: 3}
+
+	tests := []struct {
+		name    string
+		user    *types.User
+		ifMatch string
+		want    int
+	}{
+		{name: "stale If-Match", user: user, ifMatch: `"2"`, want: http.StatusPreconditionFailed},
+		{name: "unknown user", user: nil, ifMatch: `"3"`, want: http.StatusNotFound},
+		{name: "weak If-Match", user: user, ifMatch: `W/"3"`, want: http.StatusPreconditionFailed},
+		{name: "malformed If-Match", user: user, ifMatch: `3`, want: http.StatusBadRequest},
+		{name: "malformed tag in a list", user: user, ifMatch: `"3", 3`, want: http.StatusBadRequest},
+		{name: "stale list", user: user, ifMatch: `"1", W/"3", "2"`, want: http.StatusPreconditionFailed},
+		{name: "matching list", user: user, ifMatch: `"1", "3"`, want: http.StatusOK},
+		{name: "any", user: user, ifMatch: `*`, want: http.StatusOK},
+		{name: "any for an unknown user", user: nil, ifMatch: `*`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"email": "bob@example.com", "username": "bob"}`)
+			req := httptest.NewRequest(http.MethodPut, "/user/"+testUserID, body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			// The handler and the store each read the user, nobody else has
+			// the new email or username, and the update returns the user.
+			db := newFakeDB(tt.user, tt.user, nil, tt.user)
+			rec := httptest.NewRecorder()
+			newTestServer(t, db).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPatchUserWeakETag(t *testing.T) {
+	user := &types.User{ID: testUserID, Username: "alice", Email: "alice@example.com", Version: 3}
+
+	req := httptest.NewRequest(http.MethodPatch, "/user/"+testUserID, strings.NewReader(`{"username": "bob"}`))
+	req.Header.Set("Content-Type", mergePatchContentType)
+	req.Header.Set("If-Match", `W/"3"`)
+
+	rec := httptest.NewRecorder()
+	newTestServer(t, newFakeDB(user, user)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusPreconditionFailed, rec.Body)
+	}
+}
+
+func TestMissingUserStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{name: "get", method: http.MethodGet, path: "/user/" + testUserID},
+		{name: "delete", method: http.MethodDelete, path: "/user/" + testUserID},
+		{name: "purge", method: http.MethodDelete, path: "/user/" + testUserID + "?purge=true"},
+		{name: "restore", method: http.MethodPost, path: "/user/" + testUserID + "/restore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			newTestServer(t, newFakeDB(nil)).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUserConflictStatus(t *testing.T) {
+	alice := &types.User{ID: testUserID, Username: "alice", Email: "alice@example.com", Version: 3}
+	bob := &types.User{ID: "9f3c1a52-0d1e-4f7a-8a4b-2d6f0c9e7b11", Username: "bob", Email: "bob@example.com", Version: 1}
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		// users are the rows the handler and store read in turn: the user
+		// being changed, once per read of it, and then bob, who already has
+		// the email it is changed to.
+		users []*types.User
+	}{
+		{
+			name:        "put",
+			method:      http.MethodPut,
+			contentType: "application/json",
+			body:        `{"email": "bob@example.com", "username": "alice"}`,
+			users:       []*types.User{alice, alice, bob},
+		},
+		{
+			name:        "merge patch",
+			method:      http.MethodPatch,
+			contentType: mergePatchContentType,
+			body:        `{"email": "bob@example.com"}`,
+			users:       []*types.User{alice, alice, bob},
+		},
+		{
+			name:        "json patch",
+			method:      http.MethodPatch,
+			contentType: jsonPatchContentType,
+			body:        `[{"op": "replace", "path": "/email", "value": "bob@example.com"}]`,
+			users:       []*types.User{alice, alice, bob},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/user/"+testUserID, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("If-Match", `"3"`)
+
+			rec := httptest.NewRecorder()
+			newTestServer(t, newFakeDB(tt.users...)).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusConflict {
+				t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+			}
+		})
+	}
+}
+
+func TestShutdownDrainDelay(t *testing.T) {
+	const drainDelay = 100 * time.Millisecond
+
+	cursors, err := cursor.NewRandomCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(newFakeDB(), nil, chi.NewRouter(), config.HTTP{DrainDelay: drainDelay}, cursors)
+	s.setupRoutes()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- s.shutdown(context.Background()) }()
+
+	// Readiness fails right away, while we keep serving during the delay.
+	time.Sleep(drainDelay / 4)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d from /readyz while draining, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the drain delay was up")
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < drainDelay {
+		t.Errorf("shutdown took %s, want at least %s", elapsed, drainDelay)
+	}
+
+	// The delay is cut short when there is no time left.
+	s = newServer(newFakeDB(), nil, chi.NewRouter(), config.HTTP{DrainDelay: time.Hour}, cursors)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestGetUsersPageParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "last without before", query: "last=5"},
+		{name: "first and last", query: "first=5&last=5"},
+		{name: "invalid cursor", query: "after=nope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestServer(t, newFakeDB()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/user?"+tt.query, nil))
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name          string
+		adminToken    config.Secret
+		authorization string
+		wantStatus    int
+	}{
+		{name: "disabled", authorization: "Bearer " + token, wantStatus: http.StatusForbidden},
+		{name: "no token", adminToken: token, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", adminToken: token, authorization: "Bearer nope", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", adminToken: token, authorization: token, wantStatus: http.StatusUnauthorized},
+		{name: "admin", adminToken: token, authorization: "Bearer " + token, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{adminToken: tt.adminToken}
+			handler := s.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	// The operator endpoints are behind it.
+	for _, path := range []string{"/audit", "/debug/db"} {
+		rec := httptest.NewRecorder()
+		newTestServer(t, newFakeDB()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestRedactAuditData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "user", data: `{"id":"a","email":"alice@example.com","username":"alice"}`, want: `{"email":"REDACTED","id":"a","username":"alice"}`},
+		{name: "no personal data", data: `{"id":1,"userId":"a"}`, want: `{"id":1,"userId":"a"}`},
+		{name: "null", data: `null`, want: `null`},
+		{name: "missing", data: ``, want: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactAuditData(json.RawMessage(tt.data))); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}